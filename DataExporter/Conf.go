@@ -9,15 +9,60 @@ type DataDefine struct {
 	SubPath   string `json:"subPath"`
 }
 
+// OutputConf 一个导出目标, Names不为空时只导出其中的数据, Tags不为空时只导出没有标签或者标签匹配的字段
+// Package是代码生成的命名空间, Annotations为true时to_lua同时生成EmmyLua注解, Compact为true时to_json不缩进
+type OutputConf struct {
+	Tool        string   `json:"tool"`
+	OutDir      string   `json:"out_dir"`
+	Names       []string `json:"names"`
+	Tags        []string `json:"tags"`
+	Package     string   `json:"package"`
+	Annotations bool     `json:"annotations"`
+	Compact     bool     `json:"compact"`
+	// CommitDir 导出时OutDir是staging目录, 成功后替换到CommitDir
+	CommitDir string `json:"-"`
+}
+
+// Accept 数据name是否需要导出到这个目标
+func (o *OutputConf) Accept(name string) bool {
+	if len(o.Names) == 0 {
+		return true
+	}
+	for _, n := range o.Names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
 type ExportConf struct {
-	Tool    string       `json:"tool"`
-	CpuNum  int          `json:"cpu_num"`
-	SrcDir  string       `json:"src_dir"`
-	OutDir  string       `json:"out_dir"`
-	DataDef []DataDefine `json:"data_def"`
+	Tool       string       `json:"tool"`
+	CpuNum     int          `json:"cpu_num"`
+	SrcDir     string       `json:"src_dir"`
+	OutDir     string       `json:"out_dir"`
+	Outputs    []OutputConf `json:"outputs"`
+	NameRule   string       `json:"name_rule"`
+	HookDir    string       `json:"hook_dir"`
+	HookUnsafe bool         `json:"hook_unsafe"`
+	DataDef    []DataDefine `json:"data_def"`
 }
 
 const (
 	Tool_To_Json = "to_json"
 	Tool_To_Lua  = "to_lua"
+	// 紧凑的二进制格式, 读取的参考实现是internal/ToBin/Reader.go
+	Tool_To_Bin = "to_bin"
+	// 和to_json结构相同的MessagePack和CBOR
+	Tool_To_Msgpack = "to_msgpack"
+	Tool_To_Cbor    = "to_cbor"
+	// .proto和按其编码的二进制数据, 字段编号保存在out_dir中的<DataName>.fields.json
+	Tool_To_Protobuf = "to_protobuf"
+	// 代码生成, 生成读取to_json导出结果的代码
+	Tool_To_CSharp = "to_csharp"
+	Tool_To_Go     = "to_go"
+	Tool_To_Ts     = "to_ts"
 )
+
+// DefaultHookDir 没有配置hook_dir时使用当前目录下的hook目录
+const DefaultHookDir = "./hook"
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"path"
+	"sort"
+	"strings"
+	"time"
 
 	workpool "exporterX/DataExporter/WorkerPool"
 )
@@ -17,17 +20,47 @@ type ConfigParser interface {
 
 type DataExporter interface {
 	Version() string
+	// HasTool 导出目标的tool是否可以使用
+	HasTool(tool string) bool
 	Init()
-	DoExport(n int, tool string, filePath string, outDir string, dataDef *DataDefine) (string, error)
+	// DoExport 解析一次数据, 写到outputs中的每个导出目标
+	DoExport(n int, outputs []OutputConf, filePath string, dataDef *DataDefine) (string, error)
 	SetCpuNum(int)
-	AfterExport()
+	// SetNameRule 设置字段名必须匹配的正则
+	SetNameRule(rule string) error
+	// SetDataDefine 设置src_dir和data_def中所有的数据
+	SetDataDefine(srcDir string, dataDefs []DataDefine)
+	// SetHookDir 设置hook目录并加载其中的hook, 需要在SetCpuNum之前调用
+	SetHookDir(dir string) error
+	// SetHookSandbox 设置hook可以写的目录, 导出时是每个导出目标的staging目录, unsafe为true时不限制hook
+	SetHookSandbox(writeDirs []string, unsafe bool) error
+	// Inputs 返回除了excel之外影响导出结果的文件, always为true时每次都要导出
+	Inputs(dataDef *DataDefine) (files []string, always bool)
+	// Requires 返回导出names时需要一起导出的数据, all是data_def中所有的数据, 需要在Init之后调用
+	Requires(names []string, all []string) ([]string, error)
+	// Keys 返回导出成功的数据的所有key, SetKeys设置跳过导出的数据的key, 用于检查引用
+	Keys(name string) []string
+	// RefTargets 返回导出成功的数据引用的所有数据
+	RefTargets(name string) []string
+	SetKeys(name string, keys []string)
+	// WatchDirs 返回watch模式下除了src_dir还要轮询的目录, Reload重新加载其中变化了的文件
+	WatchDirs() []string
+	Reload(files []string) error
+	CheckReferences() error
+	// AfterExport 所有数据导出之后执行GlobalProcess
+	AfterExport() error
+	// WriteIndex 导出之后写一个导出目标根目录下的公共文件, dataDefs是out_dir中所有的数据, 返回写入的文件名
+	WriteIndex(output OutputConf, dataDefs []DataDefine) ([]string, error)
 }
 
 type OptionalConf struct {
 	CpuNum     int
 	SrcDir     string
 	OutDir     string
+	HookDir    string
 	ExportList []string
+	Force      bool
+	Watch      bool
 }
 
 func NewExcelExporter(parser *ConfigParser, exporter *DataExporter, confPath string, opt *OptionalConf) *ExcelExporter {
@@ -38,7 +71,10 @@ func NewExcelExporter(parser *ConfigParser, exporter *DataExporter, confPath str
 		cpuNum:     opt.CpuNum,
 		srcDir:     opt.SrcDir,
 		outDir:     opt.OutDir,
+		hookDir:    opt.HookDir,
 		exportList: opt.ExportList,
+		force:      opt.Force,
+		watch:      opt.Watch,
 	}
 }
 
@@ -48,11 +84,19 @@ type ExcelExporter struct {
 	confPath   string
 	srcDir     string
 	outDir     string
-	tool       string
+	hookDir    string
+	outputs    []OutputConf
 	cpuNum     int
 	dataDef    []DataDefine
+	allDataDef []DataDefine
 	exportList []string
+	force      bool
+	watch      bool
+	manifests  map[string]*Manifest
 	workPool   *workpool.WorkPool
+	hookUnsafe bool
+	// exporterVersion 导出程序的版本和程序文件的hash, 记录在manifest中
+	exporterVersion string
 }
 
 func (e *ExcelExporter) PrintExporterInfo() {
@@ -76,17 +120,56 @@ func (e *ExcelExporter) PrepareExport() error {
 		log.Panicf("ParseConfigFile %s got error: %s", e.confPath, err.Error())
 	}
 
-	e.tool = configData.Tool
 	if e.srcDir == "" {
 		e.srcDir = configData.SrcDir
 	}
-	if e.outDir == "" {
-		e.outDir = configData.OutDir
+	e.outputs = configData.Outputs
+	if len(e.outputs) == 0 {
+		// 没有配置outputs时, tool和out_dir就是唯一的导出目标
+		e.outputs = []OutputConf{{Tool: configData.Tool, OutDir: configData.OutDir}}
+	}
+	if e.outDir != "" {
+		if len(e.outputs) > 1 {
+			log.Panicf("-out cannot be used with %d outputs", len(e.outputs))
+		}
+		e.outputs[0].OutDir = e.outDir
+	}
+	unknown := make([]string, 0)
+	for i, output := range e.outputs {
+		if !e.exporter.HasTool(output.Tool) {
+			unknown = append(unknown, fmt.Sprintf("outputs[%d] %q", i, output.Tool))
+		}
+	}
+	if len(unknown) > 0 {
+		log.Panicf("Cannot use tool: %s", strings.Join(unknown, ", "))
 	}
 	if e.cpuNum == 0 {
 		e.cpuNum = configData.CpuNum
 	}
+	e.hookUnsafe = configData.HookUnsafe
+	if e.hookDir == "" {
+		e.hookDir = configData.HookDir
+	}
+	if e.hookDir == "" {
+		e.hookDir = DefaultHookDir
+	}
+	if exist, err := pathExists(e.hookDir); !exist {
+		if err != nil {
+			log.Panicf("Find hook_dir %s got error: %s", e.hookDir, err.Error())
+		} else {
+			log.Panicf("Find hook_dir %s got failed, set hook_dir in the config or use -hook", e.hookDir)
+		}
+	}
+	if err = e.exporter.SetHookDir(e.hookDir); err != nil {
+		log.Panicf("Load hooks in %s got error: %s", e.hookDir, err.Error())
+	}
 	e.exporter.SetCpuNum(e.cpuNum)
+	// 没有配置name_rule时不限制字段名, 只检查导出目标不能处理的名字
+	if configData.NameRule != "" {
+		if err = e.exporter.SetNameRule(configData.NameRule); err != nil {
+			log.Panicf("Bad name_rule %s: %s", configData.NameRule, err.Error())
+		}
+	}
 
 	if e.exportList != nil && len(e.exportList) > 0 {
 		e.dataDef = make([]DataDefine, 0, len(e.exportList))
@@ -101,6 +184,8 @@ func (e *ExcelExporter) PrepareExport() error {
 		e.dataDef = configData.DataDef
 	}
 	e.exportList = nil
+	e.allDataDef = configData.DataDef
+	e.exporter.SetDataDefine(e.srcDir, e.allDataDef)
 
 	if exist, err := pathExists(configData.SrcDir); !exist {
 		if err != nil {
@@ -110,63 +195,400 @@ func (e *ExcelExporter) PrepareExport() error {
 		}
 	}
 
-	if err = makePathExists(configData.OutDir); err != nil {
-		log.Panicf("Make out_dir got error: %s", err.Error())
+	for _, output := range e.outputs {
+		if err = makePathExists(output.OutDir); err != nil {
+			log.Panicf("Make out_dir got error: %s", err.Error())
+		}
 	}
 
 	log.Printf("cpu: %v\n", e.cpuNum)
 	log.Printf("src: %v\n", e.srcDir)
-	log.Printf("out: %v\n", e.outDir)
+	log.Printf("hook: %v\n", e.hookDir)
+	for _, output := range e.outputs {
+		filters := ""
+		if len(output.Names) > 0 {
+			filters += fmt.Sprintf(" names: %v", output.Names)
+		}
+		if len(output.Tags) > 0 {
+			filters += fmt.Sprintf(" tags: %v", output.Tags)
+		}
+		log.Printf("out: %v (%s)%s\n", output.OutDir, output.Tool, filters)
+	}
 
 	return err
 }
 
 func (e *ExcelExporter) BeforeExportData() {
 	e.exporter.Init()
+	e.requireDataDef()
 }
 
-func (e *ExcelExporter) DoExport() {
-	tasks := make([]workpool.Task, 0, 16)
-	ignores := make([]string, 0, 2)
+// requireDataDef 加上导出e.dataDef时GlobalProcess需要一起导出的数据
+func (e *ExcelExporter) requireDataDef() {
+	names := make([]string, 0, len(e.dataDef))
+	selected := make(map[string]bool, len(e.dataDef))
 	for _, dataDef := range e.dataDef {
+		names = append(names, dataDef.Name)
+		selected[dataDef.Name] = true
+	}
+	all := make([]string, 0, len(e.allDataDef))
+	for _, dataDef := range e.allDataDef {
+		all = append(all, dataDef.Name)
+	}
+	required, err := e.exporter.Requires(names, all)
+	if err != nil {
+		log.Panicf("Check GlobalProcess got error: %s", err.Error())
+	}
+	requiredSet := make(map[string]bool, len(required))
+	for _, name := range required {
+		requiredSet[name] = true
+	}
+	dataDefs := make([]DataDefine, 0, len(required))
+	for _, dataDef := range e.allDataDef {
+		if selected[dataDef.Name] {
+			dataDefs = append(dataDefs, dataDef)
+		} else if requiredSet[dataDef.Name] {
+			log.Printf("Export %s required by GlobalProcess", dataDef.Name)
+			dataDefs = append(dataDefs, dataDef)
+		}
+	}
+	e.dataDef = dataDefs
+}
+
+func (e *ExcelExporter) DoExport() error {
+	return e.export(e.dataDef, e.force, true)
+}
+
+// export 在每个out_dir旁边的临时目录中导出dataDefs并执行AfterExport, 全部成功后才替换到out_dir
+func (e *ExcelExporter) export(dataDefs []DataDefine, force bool, afterExport bool) (err error) {
+	stagings := make([]*Staging, 0, len(e.outputs))
+	exported := make([]DataDefine, 0, len(dataDefs))
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+			log.Printf("DoExport got panic: %v", r)
+		}
+		if err != nil {
+			for _, staging := range stagings {
+				staging.Rollback()
+			}
+			for _, manifest := range e.manifests {
+				for _, dataDef := range exported {
+					delete(manifest.Entries, dataDef.Name)
+				}
+			}
+			for _, output := range e.outputs {
+				log.Printf("Nothing in %s is changed", output.OutDir)
+			}
+		}
+	}()
+
+	outputs := make([]OutputConf, 0, len(e.outputs))
+	for _, output := range e.outputs {
+		staging, err := NewStaging(output.OutDir)
+		if err != nil {
+			log.Printf("Prepare staging for %s got error: %s", output.OutDir, err.Error())
+			return err
+		}
+		stagings = append(stagings, staging)
+		staged := output
+		staged.OutDir = staging.Dir()
+		staged.CommitDir = output.OutDir
+		outputs = append(outputs, staged)
+	}
+	// hook只能写staging目录, 和导出的文件一起提交; 导出之外不能写任何目录
+	writeDirs := make([]string, 0, len(stagings))
+	for _, staging := range stagings {
+		writeDirs = append(writeDirs, staging.Dir())
+	}
+	if err := e.exporter.SetHookSandbox(writeDirs, e.hookUnsafe); err != nil {
+		log.Printf("Set hook sandbox got error: %s", err.Error())
+		return err
+	}
+	defer e.exporter.SetHookSandbox(nil, e.hookUnsafe)
+
+	exported, errs := e.exportData(dataDefs, force, outputs)
+	if len(errs) > 0 {
+		log.Printf("DoExport got %d error(s):\n%s", len(errs), errs.Report())
+		return errs
+	}
+	if afterExport {
+		if err := e.AfterExportData(); err != nil {
+			if errs, ok := err.(ExportErrors); ok {
+				log.Printf("DoExport got %d error(s):\n%s", len(errs), errs.Report())
+			} else {
+				log.Printf("AfterExport got error: %s", err.Error())
+			}
+			return err
+		}
+	}
+	accepted := make([][]DataDefine, len(e.outputs))
+	for i := range e.outputs {
+		for _, dataDef := range exported {
+			if e.outputs[i].Accept(dataDef.Name) {
+				accepted[i] = append(accepted[i], dataDef)
+			}
+		}
+	}
+	shared, err := e.writeIndexes(outputs)
+	if err != nil {
+		log.Printf("Write index got error: %s", err.Error())
+		return err
+	}
+	if err := CommitAll(stagings, accepted, shared); err != nil {
+		log.Printf("Commit got error: %s", err.Error())
+		return err
+	}
+	e.SaveManifest()
+
+	log.Println("DoExport Success!  (*^__^*)")
+	return nil
+}
+
+// exportData 把dataDefs中的数据导出到outputs, 每个数据只解析一次,
+// force为false时跳过所有导出目标的输入都没有变化的数据, 返回导出成功的数据
+func (e *ExcelExporter) exportData(dataDefs []DataDefine, force bool, outputs []OutputConf) ([]DataDefine, ExportErrors) {
+	exported := make([]DataDefine, 0, len(dataDefs))
+	tasks := make([]workpool.Task, 0, 16)
+	errs := make(ExportErrors, 0)
+	if e.manifests == nil {
+		e.manifests = make(map[string]*Manifest, len(e.outputs))
+	}
+	for _, output := range e.outputs {
+		if _, ok := e.manifests[output.OutDir]; !ok {
+			e.manifests[output.OutDir] = LoadManifest(output.OutDir)
+		}
+	}
+	// 每个数据在它的每个导出目标的manifest中的记录, 和e.outputs的下标一一对应
+	entries := make(map[string][]*ManifestEntry)
+	deferred := make(map[string]workpool.Task)
+	deferredEntries := make(map[string][]*ManifestEntry)
+	skipped := 0
+	for _, dataDef := range dataDefs {
+		targets := make([]OutputConf, 0, len(outputs))
+		for _, output := range outputs {
+			if output.Accept(dataDef.Name) {
+				targets = append(targets, output)
+			}
+		}
+		if len(targets) == 0 {
+			continue
+		}
 		filePath := path.Join(e.srcDir, dataDef.Excel)
 		exist, _ := pathExists(filePath)
 		if !exist {
-			ignores = append(ignores, fmt.Sprintf("%s, %s not found", dataDef.Name, dataDef.Excel))
+			errs = append(errs, &CellError{
+				Workbook: dataDef.Excel,
+				Sheet:    dataDef.Sheet,
+				Reason:   fmt.Sprintf("%s not found", filePath),
+			})
 			continue
 		}
 		dataDefCp := dataDef
-		tasks = append(tasks, workpool.Task{
+		outputEntries := make([]*ManifestEntry, len(e.outputs))
+		unchanged := !force
+		var keys []string
+		for i, output := range e.outputs {
+			if !output.Accept(dataDef.Name) {
+				continue
+			}
+			entry, always, err := e.manifestEntry(filePath, &output, &dataDefCp)
+			if err != nil {
+				errs = append(errs, toExportErrors(err, &dataDefCp)...)
+				unchanged = false
+				outputEntries = nil
+				break
+			}
+			outputEntries[i] = entry
+			old, ok := e.manifests[output.OutDir].Entries[dataDef.Name]
+			if !ok || always || !old.SameInputs(entry) {
+				unchanged = false
+			} else {
+				keys = old.Keys
+			}
+		}
+		if outputEntries == nil {
+			continue
+		}
+		task := workpool.Task{
 			Id: dataDef.Name,
-			F:  func(i int) (string, error) { return e.exporter.DoExport(i, e.tool, filePath, e.outDir, &dataDefCp) },
-		})
+			F:  func(i int) (string, error) { return e.exporter.DoExport(i, targets, filePath, &dataDefCp) },
+		}
+		if unchanged {
+			// 所有导出目标的输入都没有变化, 先跳过导出, 其他数据导出之后再检查引用的数据有没有变化
+			e.exporter.SetKeys(dataDef.Name, keys)
+			deferred[dataDef.Name] = task
+			deferredEntries[dataDef.Name] = outputEntries
+			skipped++
+			continue
+		}
+		entries[dataDef.Name] = outputEntries
+		tasks = append(tasks, task)
+	}
+	exported, errs = e.runTasks(tasks, dataDefs, entries, exported, errs)
+
+	// 引用的数据的key变了时, 跳过的数据也要重新导出, 检查它的引用
+	tasks = make([]workpool.Task, 0, len(deferred))
+	for _, dataDef := range dataDefs {
+		task, ok := deferred[dataDef.Name]
+		if !ok || !e.refsChanged(dataDef.Name) {
+			continue
+		}
+		log.Printf("Export %s again, the data it references changed", dataDef.Name)
+		entries[dataDef.Name] = deferredEntries[dataDef.Name]
+		tasks = append(tasks, task)
+		skipped--
+	}
+	if skipped > 0 {
+		log.Printf("Skip %d unchanged data, use -force to export all", skipped)
 	}
+	if len(tasks) > 0 {
+		exported, errs = e.runTasks(tasks, dataDefs, entries, exported, errs)
+	}
+
+	// 所有表导出之后再检查表之间的引用
+	if err := e.exporter.CheckReferences(); err != nil {
+		if refErrs, ok := err.(ExportErrors); ok {
+			errs = append(errs, refErrs...)
+		} else {
+			errs = append(errs, &CellError{Reason: err.Error()})
+		}
+	}
+	return exported, errs
+}
+
+// runTasks 导出tasks中的数据, 导出成功的数据记录到manifest中
+func (e *ExcelExporter) runTasks(tasks []workpool.Task, dataDefs []DataDefine, entries map[string][]*ManifestEntry, exported []DataDefine, errs ExportErrors) ([]DataDefine, ExportErrors) {
 	e.workPool = workpool.NewWorkPool(tasks, e.cpuNum)
 	e.workPool.Start()
 	results := e.workPool.Results()
+	failures := e.workPool.Errors()
 
-	for _, ignore := range ignores {
-		log.Fatalf(ignore)
-	}
-
-	for _, dataDef := range e.dataDef {
+	for i := range dataDefs {
+		dataDef := &dataDefs[i]
 		if _, ok := results[dataDef.Name]; ok {
 			delete(results, dataDef.Name)
-		} else {
-			log.Fatalf("%s export failed", dataDef.Name)
+			keys := e.exporter.Keys(dataDef.Name)
+			refs := make(map[string]string)
+			for _, target := range e.exporter.RefTargets(dataDef.Name) {
+				refs[target] = hashKeys(e.currentKeys(target))
+			}
+			for j, entry := range entries[dataDef.Name] {
+				if entry != nil {
+					entry.Keys = keys
+					entry.Refs = refs
+					e.manifests[e.outputs[j].OutDir].Entries[dataDef.Name] = entry
+				}
+			}
+			exported = append(exported, *dataDef)
+		} else if err, ok := failures[dataDef.Name]; ok {
+			for _, manifest := range e.manifests {
+				delete(manifest.Entries, dataDef.Name)
+			}
+			errs = append(errs, toExportErrors(err, dataDef)...)
 		}
 	}
+	return exported, errs
+}
 
-	log.Println("DoExport Success!  (*^__^*)")
+// currentKeys 返回数据name现在的所有key, 这次导出的数据用导出结果, 跳过的数据用manifest中的记录
+func (e *ExcelExporter) currentKeys(name string) []string {
+	if keys := e.exporter.Keys(name); keys != nil {
+		return keys
+	}
+	for _, output := range e.outputs {
+		if entry, ok := e.manifests[output.OutDir].Entries[name]; ok {
+			return entry.Keys
+		}
+	}
+	return nil
+}
+
+// refsChanged 跳过的数据name引用的数据的key和上次导出时不一样时返回true
+func (e *ExcelExporter) refsChanged(name string) bool {
+	for _, output := range e.outputs {
+		entry, ok := e.manifests[output.OutDir].Entries[name]
+		if !ok {
+			continue
+		}
+		for target, hash := range entry.Refs {
+			if hashKeys(e.currentKeys(target)) != hash {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func (e *ExcelExporter) AfterExportData() {
-	if e.tool != Tool_To_Lua {
-		return
+// writeIndexes 按manifest中记录的数据在每个staging中写公共文件, 返回每个导出目标写入的文件名
+func (e *ExcelExporter) writeIndexes(outputs []OutputConf) ([][]string, error) {
+	shared := make([][]string, len(e.outputs))
+	for i, output := range e.outputs {
+		manifest := e.manifests[output.OutDir]
+		dataDefs := make([]DataDefine, 0, len(manifest.Entries))
+		for _, entry := range manifest.Entries {
+			dataDefs = append(dataDefs, entry.Define)
+		}
+		sort.Slice(dataDefs, func(a, b int) bool { return dataDefs[a].Name < dataDefs[b].Name })
+		files, err := e.exporter.WriteIndex(outputs[i], dataDefs)
+		if err != nil {
+			return nil, err
+		}
+		shared[i] = files
+	}
+	return shared, nil
+}
+
+// manifestEntry 计算一个数据这次导出的所有输入
+func (e *ExcelExporter) manifestEntry(filePath string, output *OutputConf, dataDef *DataDefine) (*ManifestEntry, bool, error) {
+	hash, err := hashFile(filePath)
+	if err != nil {
+		return nil, false, err
+	}
+	if e.exporterVersion == "" {
+		e.exporterVersion = exporterHash(e.exporter.Version())
+	}
+	files, always := e.exporter.Inputs(dataDef)
+	// 只记录影响导出结果的配置, out_dir就是manifest所在的目录
+	outputConf := *output
+	outputConf.OutDir = ""
+	outputConf.Names = nil
+	hooks := make(map[string]string, len(files))
+	for _, file := range files {
+		if hooks[file], err = hashFile(file); err != nil {
+			return nil, false, err
+		}
+	}
+	return &ManifestEntry{
+		Define:   *dataDef,
+		Hash:     hash,
+		Hooks:    hooks,
+		Output:   outputConf,
+		Exporter: e.exporterVersion,
+	}, always, nil
+}
+
+// SaveManifest 整个导出成功后记录这次的输入, 下次导出时跳过没有变化的数据
+func (e *ExcelExporter) SaveManifest() {
+	for outDir, manifest := range e.manifests {
+		if err := manifest.Save(outDir); err != nil {
+			log.Printf("Save manifest %s got error: %s", outDir, err.Error())
+		}
+	}
+}
+
+// AfterExportData 有to_lua导出目标时执行一次GlobalProcess, 处理后的数据写到这个数据的所有导出目标
+func (e *ExcelExporter) AfterExportData() error {
+	hasLua := false
+	for _, output := range e.outputs {
+		hasLua = hasLua || output.Tool == Tool_To_Lua
+	}
+	if !hasLua {
+		return nil
 	}
 	log.Println("==================================")
 	log.Println("Next is programers's Data analysis")
-	e.exporter.AfterExport()
+	return e.exporter.AfterExport()
 }
 
 func (e *ExcelExporter) Run() {
@@ -174,7 +596,11 @@ func (e *ExcelExporter) Run() {
 
 	e.BeforeExportData()
 
-	e.DoExport()
+	if err := e.DoExport(); err != nil && !e.watch {
+		log.Fatalf("DoExport failed!  (T__T)")
+	}
 
-	e.AfterExportData()
+	if e.watch {
+		e.Watch(time.Second)
+	}
 }
@@ -0,0 +1,89 @@
+package dataExporter
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// CellError 描述一个单元格(或整张表)导表失败的原因
+type CellError struct {
+	Workbook string
+	Sheet    string
+	Cell     string
+	Key      string
+	Type     string
+	Text     string
+	Reason   string
+}
+
+func (e *CellError) Position() string {
+	pos := e.Workbook + "!" + e.Sheet
+	if e.Cell != "" {
+		pos += "!" + e.Cell
+	}
+	if e.Key != "" && e.Type != "" {
+		pos += " (" + e.Key + ": " + e.Type + ")"
+	} else if e.Key != "" || e.Type != "" {
+		pos += " (" + e.Key + e.Type + ")"
+	}
+	return pos
+}
+
+func (e *CellError) Error() string {
+	if e.Text == "" {
+		return e.Position() + " " + e.Reason
+	}
+	return fmt.Sprintf("%s %q %s", e.Position(), e.Text, e.Reason)
+}
+
+// ExportErrors 收集一次导表中的所有错误, 导表结束后统一输出
+type ExportErrors []*CellError
+
+func (errs ExportErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	return fmt.Sprintf("%d errors, first: %s", len(errs), errs[0].Error())
+}
+
+// Report 按工作簿和表单分组输出所有错误
+func (errs ExportErrors) Report() string {
+	groups := make(map[string]ExportErrors)
+	names := make([]string, 0, 4)
+	for _, err := range errs {
+		name := err.Workbook + "!" + err.Sheet
+		if _, exist := groups[name]; !exist {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], err)
+	}
+	sort.Strings(names)
+
+	var buffer bytes.Buffer
+	for _, name := range names {
+		group := groups[name]
+		buffer.WriteString(fmt.Sprintf("%s: %d error(s)\n", name, len(group)))
+		for _, err := range group {
+			buffer.WriteString("    ")
+			buffer.WriteString(err.Error())
+			buffer.WriteString("\n")
+		}
+	}
+	return buffer.String()
+}
+
+// toExportErrors 把任务返回的error统一转换成ExportErrors
+func toExportErrors(err error, dataDef *DataDefine) ExportErrors {
+	if errs, ok := err.(ExportErrors); ok {
+		return errs
+	}
+	if cellErr, ok := err.(*CellError); ok {
+		return ExportErrors{cellErr}
+	}
+	return ExportErrors{&CellError{
+		Workbook: dataDef.Excel,
+		Sheet:    dataDef.Sheet,
+		Reason:   err.Error(),
+	}}
+}
@@ -32,3 +32,41 @@ func RegisterDataExporter(exporter exporter.DataExporter) {
 func GetDataExporter() *exporter.DataExporter {
 	return dataExporter
 }
+
+var (
+	writers      = make(map[string]exporter.NewWriter)
+	indexWriters = make(map[string]exporter.IndexWriter)
+)
+
+// RegisterWriter 注册一种导出格式, 之后导出目标的tool可以配置成这个名字
+func RegisterWriter(tool string, newWriter exporter.NewWriter) {
+	if newWriter == nil {
+		panic("factory: Register writer " + tool + " is nil")
+	}
+	if _, exist := writers[tool]; exist {
+		panic("factory: Register writer " + tool + " twice")
+	}
+
+	writers[tool] = newWriter
+}
+
+// GetWriter 没有注册的tool返回nil
+func GetWriter(tool string) exporter.NewWriter {
+	return writers[tool]
+}
+
+// RegisterIndexWriter 注册导出格式在导出目标根目录下的公共文件, 不需要公共文件的格式不用注册
+func RegisterIndexWriter(tool string, indexWriter exporter.IndexWriter) {
+	if indexWriter == nil {
+		panic("factory: Register index writer " + tool + " is nil")
+	}
+	if _, exist := indexWriters[tool]; exist {
+		panic("factory: Register index writer " + tool + " twice")
+	}
+
+	indexWriters[tool] = indexWriter
+}
+
+func GetIndexWriter(tool string) exporter.IndexWriter {
+	return indexWriters[tool]
+}
@@ -0,0 +1,110 @@
+package dataExporter
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+)
+
+const (
+	ManifestFile = ".exporter_manifest.json"
+	// ManifestVersion manifest格式变化时增加, 旧的manifest全部失效, 导出格式的变化由Exporter记录
+	ManifestVersion = 6
+)
+
+// ManifestEntry 记录一个数据上次成功导出时的所有输入
+type ManifestEntry struct {
+	Define   DataDefine        `json:"define"`
+	Hash     string            `json:"hash"`
+	Hooks    map[string]string `json:"hooks"`
+	Output   OutputConf        `json:"output"`
+	Exporter string            `json:"exporter"`
+	Keys     []string          `json:"keys"`
+	// Refs 引用的每个数据在这次导出时所有key的hash, 变化时需要重新检查引用
+	Refs map[string]string `json:"refs"`
+}
+
+// SameInputs 两次导出的输入完全一致时可以跳过导出
+func (m *ManifestEntry) SameInputs(other *ManifestEntry) bool {
+	return m.Hash == other.Hash &&
+		reflect.DeepEqual(m.Output, other.Output) &&
+		m.Exporter == other.Exporter &&
+		reflect.DeepEqual(m.Define, other.Define) &&
+		reflect.DeepEqual(m.Hooks, other.Hooks)
+}
+
+type Manifest struct {
+	Version int                       `json:"version"`
+	Entries map[string]*ManifestEntry `json:"entries"`
+}
+
+// LoadManifest 读取outDir中的manifest, 不存在或者版本不一致时返回空的manifest
+func LoadManifest(outDir string) *Manifest {
+	manifest := &Manifest{
+		Version: ManifestVersion,
+		Entries: make(map[string]*ManifestEntry),
+	}
+	content, err := ioutil.ReadFile(path.Join(outDir, ManifestFile))
+	if err != nil {
+		return manifest
+	}
+	old := &Manifest{}
+	if err := json.Unmarshal(content, old); err != nil || old.Version != ManifestVersion || old.Entries == nil {
+		return manifest
+	}
+	return old
+}
+
+func (m *Manifest) Save(outDir string) error {
+	content, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(outDir, ManifestFile), content, 0644)
+}
+
+// hashFile 返回文件内容的sha1
+func hashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashKeys 返回一个数据的所有key的hash, 和key的顺序无关
+func hashKeys(keys []string) string {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	h := sha1.New()
+	for _, key := range sorted {
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// exporterHash 返回导出程序的版本和程序文件的hash, 重新编译的程序导出格式可能变了, 旧的记录全部失效
+func exporterHash(version string) string {
+	file, err := os.Executable()
+	if err == nil {
+		var hash string
+		if hash, err = hashFile(file); err == nil {
+			return version + "@" + hash
+		}
+	}
+	log.Printf("Hash exporter got error: %s, manifest only checks the exporter version", err.Error())
+	return version
+}
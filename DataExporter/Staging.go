@@ -0,0 +1,140 @@
+package dataExporter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Staging 导出时先写到out_dir旁边的临时目录, 整个导出成功后再替换到out_dir,
+// 导出失败时删除临时目录, out_dir中原来的文件保持不变
+type Staging struct {
+	outDir string
+	dir    string
+}
+
+func NewStaging(outDir string) (*Staging, error) {
+	outDir = filepath.Clean(outDir)
+	dir, err := ioutil.TempDir(filepath.Dir(outDir), filepath.Base(outDir)+".staging-")
+	if err != nil {
+		return nil, err
+	}
+	return &Staging{outDir: outDir, dir: dir}, nil
+}
+
+func (s *Staging) Dir() string {
+	return s.dir
+}
+
+func (s *Staging) Rollback() {
+	if err := os.RemoveAll(s.dir); err != nil {
+		log.Printf("Remove staging %s got error: %s", s.dir, err.Error())
+	}
+}
+
+type stagedMove struct {
+	from string
+	to   string
+}
+
+// CommitAll 把每个staging中dataDefs[i]导出的文件和根目录下的公共文件shared[i]替换到各自的out_dir,
+// 其中一个失败时已经替换的out_dir也会恢复, 保证所有导出目标一致
+func CommitAll(stagings []*Staging, dataDefs [][]DataDefine, shared [][]string) error {
+	restores := make([]func(), 0, len(stagings))
+	for i, staging := range stagings {
+		restore, err := staging.swap(dataDefs[i], shared[i])
+		if err != nil {
+			for j := len(restores) - 1; j >= 0; j-- {
+				restores[j]()
+			}
+			return err
+		}
+		restores = append(restores, restore)
+	}
+	// 提交完成, 删除临时目录和其中的备份
+	for _, staging := range stagings {
+		staging.Rollback()
+	}
+	return nil
+}
+
+// swap 每个数据导出的是subPath下的<name>目录和<name>.*文件, 先把out_dir中旧的文件移到备份目录,
+// 再移入新的文件, shared是根目录下的公共文件; 失败时恢复旧的文件, 成功时返回的restore在删除临时目录之前可以撤销这次替换
+func (s *Staging) swap(dataDefs []DataDefine, shared []string) (func(), error) {
+	moves := make([]stagedMove, 0, len(dataDefs)+len(shared))
+	for _, name := range shared {
+		moves = append(moves, stagedMove{from: filepath.Join(s.dir, name), to: filepath.Join(s.outDir, name)})
+	}
+	stales := make([]string, 0)
+	for _, dataDef := range dataDefs {
+		stagedDir := filepath.Join(s.dir, dataDef.SubPath)
+		files, err := ioutil.ReadDir(stagedDir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		hasDir := false
+		for _, file := range files {
+			if file.Name() != dataDef.Name && !strings.HasPrefix(file.Name(), dataDef.Name+".") {
+				continue
+			}
+			hasDir = hasDir || (file.IsDir() && file.Name() == dataDef.Name)
+			moves = append(moves, stagedMove{
+				from: filepath.Join(stagedDir, file.Name()),
+				to:   filepath.Join(s.outDir, dataDef.SubPath, file.Name()),
+			})
+		}
+		if !hasDir {
+			// 和writer一样, 不再是单行一个文件时删除旧的目录
+			stales = append(stales, filepath.Join(s.outDir, dataDef.SubPath, dataDef.Name))
+		}
+	}
+
+	backupDir := filepath.Join(s.dir, ".backup")
+	if err := os.Mkdir(backupDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	backups := make([]stagedMove, 0, len(moves))
+	moved := make([]string, 0, len(moves))
+	restore := func() {
+		for _, target := range moved {
+			os.RemoveAll(target)
+		}
+		for i := len(backups) - 1; i >= 0; i-- {
+			os.Rename(backups[i].to, backups[i].from)
+		}
+	}
+
+	targets := make([]string, 0, len(moves)+len(stales))
+	for _, move := range moves {
+		targets = append(targets, move.to)
+	}
+	targets = append(targets, stales...)
+	for i, target := range targets {
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			continue
+		}
+		backup := stagedMove{from: target, to: filepath.Join(backupDir, strconv.Itoa(i))}
+		if err := os.Rename(backup.from, backup.to); err != nil {
+			restore()
+			return nil, fmt.Errorf("backup %s got error: %s", target, err.Error())
+		}
+		backups = append(backups, backup)
+	}
+
+	for _, move := range moves {
+		if err := os.MkdirAll(filepath.Dir(move.to), os.ModePerm); err != nil {
+			restore()
+			return nil, err
+		}
+		if err := os.Rename(move.from, move.to); err != nil {
+			restore()
+			return nil, fmt.Errorf("move %s to %s got error: %s", move.from, move.to, err.Error())
+		}
+		moved = append(moved, move.to)
+	}
+	return restore, nil
+}
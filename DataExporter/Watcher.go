@@ -0,0 +1,176 @@
+package dataExporter
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Excel保存时产生的锁文件前缀
+	ExcelLockPrefix = "~$"
+	// 文件停止变化这么久之后才重新导出, 避免Excel还没保存完
+	WatchDebounce = time.Second
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshot 记录src_dir中所有excel和exporter关心的目录中所有lua文件的状态
+func (e *ExcelExporter) snapshot() map[string]fileState {
+	files := make(map[string]fileState)
+	walk := func(root string, ext ...string) {
+		filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), ExcelLockPrefix) {
+				return nil
+			}
+			for _, suffix := range ext {
+				if strings.HasSuffix(info.Name(), suffix) {
+					files[filepath.Clean(filePath)] = fileState{info.ModTime(), info.Size()}
+					break
+				}
+			}
+			return nil
+		})
+	}
+	walk(e.srcDir, ".xlsx", ".xlsm")
+	for _, dir := range e.exporter.WatchDirs() {
+		walk(dir, ".lua")
+	}
+	return files
+}
+
+// Watch 导出之后不退出, 每隔interval轮询一次文件变化, 只重新导出变化了的数据
+func (e *ExcelExporter) Watch(interval time.Duration) {
+	log.Printf("Watching %s and %v, press Ctrl+C to exit", e.srcDir, e.exporter.WatchDirs())
+	last := e.snapshot()
+	pending := make(map[string]bool)
+	var lastChange time.Time
+	for {
+		time.Sleep(interval)
+		current := e.snapshot()
+		changed := false
+		for file, state := range current {
+			if old, ok := last[file]; !ok || old != state {
+				pending[file] = true
+				changed = true
+			}
+		}
+		for file := range last {
+			if _, ok := current[file]; !ok {
+				pending[file] = true
+				changed = true
+			}
+		}
+		last = current
+		if changed {
+			lastChange = time.Now()
+			continue
+		}
+		if len(pending) == 0 || time.Since(lastChange) < WatchDebounce {
+			continue
+		}
+
+		files := make([]string, 0, len(pending))
+		for file := range pending {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		pending = make(map[string]bool)
+		e.reexport(files)
+	}
+}
+
+// referencing 返回引用了dataDefs中的数据的其他数据, 需要一起重新导出检查引用
+func (e *ExcelExporter) referencing(dataDefs []DataDefine) []DataDefine {
+	selected := make(map[string]bool, len(dataDefs))
+	for _, dataDef := range dataDefs {
+		selected[dataDef.Name] = true
+	}
+	result := make([]DataDefine, 0)
+	for _, dataDef := range e.dataDef {
+		if selected[dataDef.Name] {
+			continue
+		}
+		for _, manifest := range e.manifests {
+			entry, ok := manifest.Entries[dataDef.Name]
+			if !ok {
+				continue
+			}
+			for target := range entry.Refs {
+				if selected[target] {
+					selected[dataDef.Name] = true
+				}
+			}
+		}
+		if selected[dataDef.Name] {
+			result = append(result, dataDef)
+		}
+	}
+	return result
+}
+
+// reexport 重新导出使用了files的数据, 缓存数据变化时重新执行AfterExport
+func (e *ExcelExporter) reexport(files []string) {
+	log.Println("==================================")
+	log.Printf("Changed: %v", files)
+	excels := make(map[string]bool)
+	others := make(map[string]bool)
+	for _, file := range files {
+		if strings.HasSuffix(file, ".lua") {
+			others[file] = true
+		} else {
+			excels[file] = true
+		}
+	}
+	if len(others) > 0 {
+		reloads := make([]string, 0, len(others))
+		for file := range others {
+			reloads = append(reloads, file)
+		}
+		sort.Strings(reloads)
+		if err := e.exporter.Reload(reloads); err != nil {
+			log.Printf("Reload got error: %s", err.Error())
+			return
+		}
+	}
+
+	dataDefs := make([]DataDefine, 0, 4)
+	cached := make([]DataDefine, 0, 4)
+	cacheChanged := false
+	for _, dataDef := range e.dataDef {
+		selected := excels[filepath.Clean(filepath.Join(e.srcDir, dataDef.Excel))]
+		inputs, always := e.exporter.Inputs(&dataDef)
+		for _, input := range inputs {
+			if others[filepath.Clean(input)] {
+				selected = true
+			}
+		}
+		if always {
+			cached = append(cached, dataDef)
+			cacheChanged = cacheChanged || selected
+		} else if selected {
+			dataDefs = append(dataDefs, dataDef)
+		}
+	}
+	if cacheChanged {
+		// 缓存数据之间互相依赖, 需要全部重新导出后再执行AfterExport
+		dataDefs = append(dataDefs, cached...)
+	}
+	dataDefs = append(dataDefs, e.referencing(dataDefs)...)
+	if len(dataDefs) == 0 {
+		log.Println("No data uses the changed files")
+		return
+	}
+
+	if err := e.export(dataDefs, true, cacheChanged); err != nil {
+		log.Println("DoExport failed!  (T__T)  Waiting for next change...")
+		return
+	}
+	log.Printf("DoExport %d data, waiting for next change...", len(dataDefs))
+}
@@ -1,6 +1,10 @@
 package workpool
 
-import "os"
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+)
 
 type Task struct {
 	Id   string
@@ -18,6 +22,7 @@ type WorkPool struct {
 	tasksSize   int
 	tasksChan   chan Task
 	resultsChan chan Task
+	errors      map[string]error
 	Results     func() map[string]string
 }
 
@@ -33,6 +38,7 @@ func NewWorkPool(tasks []Task, poolsize int) *WorkPool {
 		tasksSize:   len(tasks),
 		tasksChan:   tasksChan,
 		resultsChan: resultsChan,
+		errors:      make(map[string]error),
 	}
 	pool.Results = pool.results
 	return pool
@@ -45,15 +51,21 @@ func (p *WorkPool) Start() {
 }
 
 func (p *WorkPool) work(n int) {
+	for task := range p.tasksChan {
+		task.Info, task.Err = p.do(&task, n)
+		p.resultsChan <- task
+	}
+}
+
+// do 执行任务, 任务中的panic转换成error, 不影响其他任务
+func (p *WorkPool) do(task *Task, n int) (info string, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			os.Exit(1)
+			log.Printf("task %s panic: %v\n%s", task.Id, e, debug.Stack())
+			err = fmt.Errorf("panic: %v", e)
 		}
 	}()
-	for task := range p.tasksChan {
-		task.Info, task.Err = task.Do(n)
-		p.resultsChan <- task
-	}
+	return task.Do(n)
 }
 
 func (p *WorkPool) results() map[string]string {
@@ -63,7 +75,16 @@ func (p *WorkPool) results() map[string]string {
 		if _, ok := result[task.Id]; ok {
 			panic("Duplicate " + task.Id)
 		}
+		if task.Err != nil {
+			p.errors[task.Id] = task.Err
+			continue
+		}
 		result[task.Id] = task.Info
 	}
 	return result
 }
+
+// Errors 返回失败的任务, 需要在Results之后调用
+func (p *WorkPool) Errors() map[string]error {
+	return p.errors
+}
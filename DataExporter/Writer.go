@@ -0,0 +1,32 @@
+package dataExporter
+
+import (
+	schema "exporterX/internal/Schema"
+)
+
+// WriteTarget 一个数据写到一个导出目标时需要的信息
+type WriteTarget struct {
+	Output    OutputConf
+	DataDef   *DataDefine
+	OutDir    string // 导出目标的out_dir加上数据的subPath
+	CommitDir string // 导出成功后OutDir替换到的目录, 可以读取上次导出的文件
+	FilePath  string // 数据所在的excel
+}
+
+// Writer 一种导出格式, 每个数据写到每个导出目标时创建一个, 依次调用Begin, WriteMapData或WriteRows, Finish
+// 写入失败时和解析一样直接panic, 由DoExport转换成这个数据的错误
+type Writer interface {
+	// Begin 开始写一个数据, table是按导出目标的tags过滤之后的数据结构
+	Begin(table *schema.Table)
+	// WriteMapData 写isMap的数据
+	WriteMapData(data map[string]interface{})
+	// WriteRows 写按行配置的数据, keysOrder是字段的顺序, rowsOrder是行的顺序
+	WriteRows(data map[string]interface{}, keysOrder []string, rowsOrder []string)
+	Finish()
+}
+
+// NewWriter 为一次写入创建Writer
+type NewWriter func(target WriteTarget) Writer
+
+// IndexWriter 一个导出目标的所有数据写完之后写根目录下的公共文件, dataDefs是out_dir中所有的数据, 返回写入的文件名
+type IndexWriter func(output OutputConf, dataDefs []DataDefine) ([]string, error)
@@ -9,6 +9,15 @@ import (
 	factory "exporterX/DataExporter/Factory"
 	_ "exporterX/internal/JsonParser"
 	_ "exporterX/internal/SnowExporter"
+	_ "exporterX/internal/ToBin"
+	_ "exporterX/internal/ToCSharp"
+	_ "exporterX/internal/ToCbor"
+	_ "exporterX/internal/ToGo"
+	_ "exporterX/internal/ToJson"
+	_ "exporterX/internal/ToLua"
+	_ "exporterX/internal/ToMsgpack"
+	_ "exporterX/internal/ToProtobuf"
+	_ "exporterX/internal/ToTs"
 
 	app "exporterX/DataExporter"
 )
@@ -28,6 +37,9 @@ var confPath = flag.String("conf", "conf.json", "配置文件")
 var cpuNum = flag.Int("cpu", 0, "使用几核运行")
 var srcDir = flag.String("src", "", "数值表路径")
 var outDir = flag.String("out", "", "导出路径")
+var hookDir = flag.String("hook", "", "hook目录")
+var force = flag.Bool("force", false, "忽略manifest, 导出所有数据")
+var watch = flag.Bool("watch", false, "导出后不退出, excel或hook变化时重新导出")
 
 var exportList arrayFlags
 
@@ -48,7 +60,10 @@ func main() {
 		CpuNum:     *cpuNum,
 		SrcDir:     *srcDir,
 		OutDir:     *outDir,
+		HookDir:    *hookDir,
 		ExportList: exportList,
+		Force:      *force,
+		Watch:      *watch,
 	}
 	excelExporter := app.NewExcelExporter(factory.GetConfigParser(), factory.GetDataExporter(), *confPath, optionalConf)
 	excelExporter.PrintExporterInfo()
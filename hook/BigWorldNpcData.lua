@@ -1,14 +1,4 @@
-
-local stringSplit = function(input, sep)
-    local pos,arr = 1, {}
-    -- for each divider found
-    for st,sp in function() return string.find(input, sep, pos, true) end do
-        table.insert(arr, string.sub(input, pos, st - 1))
-        pos = sp + 1
-    end
-    arr[#arr+1] = string.sub(input, pos)
-    return arr
-end
+local exporter = require("exporter")
 
 local gridsToPosition = function(x, y)
 	if x < 0 then
@@ -27,11 +17,11 @@ function BigWorldNpcData_VolumePoints(text)
     if text == "" then
         return r
     end
-    local arr = stringSplit(text, ";")
+    local arr = exporter.split(text, ";")
     for _, posPairText in ipairs(arr) do
         posPairText = string.sub(posPairText, 2, string.len(posPairText)-1)
-        local x, y = unpack(stringSplit(posPairText, "#"))
-        r.elements[#r.elements+1] = gridsToPosition(tonumber(x), tonumber(y))
+        local x, y = unpack(exporter.split(posPairText, "#"))
+        r.elements[#r.elements+1] = gridsToPosition(exporter.tonumber(x), exporter.tonumber(y))
     end
     return r
 end
@@ -43,11 +33,11 @@ function BigWorldNpcData_ReactPoints(text)
     if text == "" then
         return r
     end
-    local arr = stringSplit(text, ";")
+    local arr = exporter.split(text, ";")
     for _, posPairText in ipairs(arr) do
         posPairText = string.sub(posPairText, 2, string.len(posPairText)-1)
-        local x, y = unpack(stringSplit(posPairText, "#"))
-        r.elements[#r.elements+1] = gridsToPosition(tonumber(x), tonumber(y))
+        local x, y = unpack(exporter.split(posPairText, "#"))
+        r.elements[#r.elements+1] = gridsToPosition(exporter.tonumber(x), exporter.tonumber(y))
     end
     return r
 end
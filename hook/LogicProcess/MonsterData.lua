@@ -1,18 +1,7 @@
-local M = {}
+local exporter = require("exporter")
 
+local M = {}
 
-local function dump(o)
-    if type(o) == 'table' then
-       local s = '{ '
-       for k,v in pairs(o) do
-          if type(k) ~= 'number' then k = '"'..k..'"' end
-          s = s .. '['..k..'] = ' .. dump(v) .. ','
-       end
-       return s .. '} '
-    else
-       return tostring(o)
-    end
- end
 
 local getFuncData = function(data, lv)
     if type(data) == 'table' then
@@ -42,7 +31,7 @@ local getFuncData = function(data, lv)
         elseif data[1] == 3 then
             return data
         else
-            error(dump(data))
+            error(exporter.dump(data))
         end
     else
         return data
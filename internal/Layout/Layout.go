@@ -0,0 +1,194 @@
+// Package layout 是to_json, to_msgpack, to_cbor共用的文件结构, 三者导出的结构完全一致, 只有编码不同
+package layout
+
+import (
+	"bytes"
+	schema "exporterX/internal/Schema"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// Encoder 一种编码, 只负责写单个的值和对象数组的头尾, 文件的组织和字段的顺序由Writer决定
+type Encoder interface {
+	// Name 编码的名字, 用在错误信息中
+	Name() string
+	// Ext 文件后缀, 比如.json
+	Ext() string
+	// BeginMap 有n个字段的对象, 之后是n组Key和值, 最后是EndMap
+	BeginMap(buffer *bytes.Buffer, n int)
+	// Key 第i个字段的名字
+	Key(buffer *bytes.Buffer, i int, key string)
+	EndMap(buffer *bytes.Buffer)
+	// BeginList 有n个元素的数组, 之后是n组Elem和值, 最后是EndList
+	BeginList(buffer *bytes.Buffer, n int)
+	// Elem 第i个元素之前调用
+	Elem(buffer *bytes.Buffer, i int)
+	EndList(buffer *bytes.Buffer)
+	// Scalar 写数组和对象之外的值, 不能编码时返回error
+	Scalar(buffer *bytes.Buffer, value interface{}) error
+	// Format 写入文件之前处理整个文件的内容, 比如json的缩进
+	Format(content []byte) ([]byte, error)
+}
+
+// Writer 按Encoder导出数据, 实现了exporter.Writer
+type Writer struct {
+	logger        *log.Logger
+	encoder       Encoder
+	DataName      string
+	OutPath       string
+	OneRowOneFile bool
+	// IntKey 行的key是整数, 由Begin按key列的类型设置
+	IntKey bool
+}
+
+// NewWriter 先删除旧的文件和单行一个文件的目录
+func NewWriter(dataName string, outPath string, oneRowOneFile bool, encoder Encoder) *Writer {
+	logger := log.New(os.Stdout, "["+dataName+"]: ", log.Lshortfile)
+	if _, err := os.Stat(outPath); os.IsNotExist(err) {
+		os.MkdirAll(outPath, os.ModePerm)
+	}
+	for _, old := range []string{path.Join(outPath, dataName+encoder.Ext()), path.Join(outPath, dataName)} {
+		if _, err := os.Stat(old); err == nil {
+			if err := os.RemoveAll(old); err != nil {
+				logger.Panicf("delete %s got error %s", old, err.Error())
+			}
+		}
+	}
+	if oneRowOneFile {
+		dirPath := path.Join(outPath, dataName)
+		if err := os.Mkdir(dirPath, os.ModePerm); err != nil {
+			logger.Panicf("Mkdir %s got error: %s", dirPath, err.Error())
+		}
+	}
+	return &Writer{logger: logger, encoder: encoder, DataName: dataName, OutPath: outPath, OneRowOneFile: oneRowOneFile}
+}
+
+func (w *Writer) Begin(table *schema.Table) {
+	w.IntKey = table.IntKey()
+}
+
+func (w *Writer) WriteMapData(data map[string]interface{}) {
+	w.WriteData(data, nil, nil, true)
+}
+
+func (w *Writer) WriteRows(data map[string]interface{}, keysOrder []string, rowsOrder []string) {
+	w.WriteData(data, keysOrder, rowsOrder, false)
+}
+
+func (w *Writer) Finish() {}
+
+func (w *Writer) writeFile(filePath string, content []byte) {
+	content, err := w.encoder.Format(content)
+	if err != nil {
+		w.logger.Panicf("format %s got error %s", filePath, err.Error())
+	}
+	if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+		w.logger.Panicf(err.Error())
+	}
+}
+
+// WriteData 按rowsOrder的行顺序和keysOrder的字段顺序导出, isMap数据和其他map按key排序, 行的key是字符串
+func (w *Writer) WriteData(data map[string]interface{}, keysOrder []string, rowsOrder []string, isMap bool) {
+	ext := w.encoder.Ext()
+	filePath := path.Join(w.OutPath, w.DataName+ext)
+	if isMap {
+		var buffer bytes.Buffer
+		w.writeObject(&buffer, data, sortedKeys(data))
+		w.writeFile(filePath, buffer.Bytes())
+		return
+	}
+
+	if w.OneRowOneFile {
+		for _, id := range rowsOrder {
+			var buffer bytes.Buffer
+			w.writeObject(&buffer, data[id].(map[string]interface{}), keysOrder)
+			w.writeFile(path.Join(w.OutPath, w.DataName, id+ext), buffer.Bytes())
+		}
+		var buffer bytes.Buffer
+		w.writeIndexes(&buffer, rowsOrder)
+		w.writeFile(path.Join(w.OutPath, w.DataName, "index"+ext), buffer.Bytes())
+		return
+	}
+
+	var buffer bytes.Buffer
+	w.encoder.BeginMap(&buffer, len(rowsOrder))
+	for i, id := range rowsOrder {
+		w.encoder.Key(&buffer, i, id)
+		w.writeObject(&buffer, data[id].(map[string]interface{}), keysOrder)
+	}
+	w.encoder.EndMap(&buffer)
+	w.writeFile(filePath, buffer.Bytes())
+}
+
+// writeIndexes key列是整数时按数值排序导出整数, 否则按字符串排序
+func (w *Writer) writeIndexes(buffer *bytes.Buffer, indexes []string) {
+	sorted := make([]interface{}, 0, len(indexes))
+	if w.IntKey {
+		intIndexes := make([]int, 0, len(indexes))
+		for _, index := range indexes {
+			intIndex, err := strconv.Atoi(index)
+			if err != nil {
+				w.logger.Panicf("key %q of %s is not an integer", index, w.DataName)
+			}
+			intIndexes = append(intIndexes, intIndex)
+		}
+		sort.Ints(intIndexes)
+		for _, index := range intIndexes {
+			sorted = append(sorted, index)
+		}
+	} else {
+		strIndexes := append([]string{}, indexes...)
+		sort.Strings(strIndexes)
+		for _, index := range strIndexes {
+			sorted = append(sorted, index)
+		}
+	}
+	w.writeValue(buffer, sorted)
+}
+
+// writeObject 按keys的顺序导出对象, 不在keys中的字段不导出
+func (w *Writer) writeObject(buffer *bytes.Buffer, data map[string]interface{}, keys []string) {
+	present := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := data[key]; ok {
+			present = append(present, key)
+		}
+	}
+	w.encoder.BeginMap(buffer, len(present))
+	for i, key := range present {
+		w.encoder.Key(buffer, i, key)
+		w.writeValue(buffer, data[key])
+	}
+	w.encoder.EndMap(buffer)
+}
+
+func (w *Writer) writeValue(buffer *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		w.encoder.BeginList(buffer, len(v))
+		for i, elem := range v {
+			w.encoder.Elem(buffer, i)
+			w.writeValue(buffer, elem)
+		}
+		w.encoder.EndList(buffer)
+	case map[string]interface{}:
+		w.writeObject(buffer, v, sortedKeys(v))
+	default:
+		if err := w.encoder.Scalar(buffer, value); err != nil {
+			w.logger.Panicf("%T %v cannot be convert to %s: %s", value, value, w.encoder.Name(), err.Error())
+		}
+	}
+}
+
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
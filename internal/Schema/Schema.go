@@ -0,0 +1,79 @@
+package schema
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	Any   = "Any"
+	Int   = "Int"
+	Float = "Float"
+	Str   = "Str"
+	Bool  = "Bool"
+	List  = "List"
+	Dict  = "Dict"
+	Enum  = "Enum"
+	Func  = "Func"
+)
+
+// Type 导出数据的类型, 由excel的类型行转换, 和具体的导出工具无关
+// lua劫持或者GlobalProcess.lua新增的字段类型未知, 是Any
+type Type struct {
+	Kind   string
+	Elem   *Type
+	Fields []*Field
+	Enum   []EnumValue
+	RefTo  string
+}
+
+// Ref 引用的数据名, List(Ref(X))也返回X
+func (t *Type) Ref() string {
+	if t.Kind == List {
+		return t.Elem.Ref()
+	}
+	return t.RefTo
+}
+
+// EnumValue Enum的一个取值
+type EnumValue struct {
+	Name  string
+	Value int
+}
+
+// Field 数据的一个字段或者Dict中的一个key
+type Field struct {
+	Name string
+	Type *Type
+}
+
+// Table 一个数据的结构, Key是第一列的类型, isMap数据没有Key, Fields是每个key
+// SubPath和RowFile决定to_json导出文件的位置
+type Table struct {
+	Name    string
+	SubPath string
+	IsMap   bool
+	RowFile bool
+	Key     *Type
+	Fields  []*Field
+}
+
+// IntKey 行数据的key是否是整数
+func (t *Table) IntKey() bool {
+	return t.Key != nil && t.Key.Kind == Int
+}
+
+// TypeName 把字段路径拼成嵌套类型的名字, 比如Info.a -> InfoA
+func TypeName(path ...string) string {
+	var builder strings.Builder
+	for _, name := range path {
+		r, size := utf8.DecodeRuneInString(name)
+		if size == 0 {
+			continue
+		}
+		builder.WriteRune(unicode.ToUpper(r))
+		builder.WriteString(name[size:])
+	}
+	return builder.String()
+}
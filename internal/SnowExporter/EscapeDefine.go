@@ -0,0 +1,81 @@
+package snowExporter
+
+import (
+	"strings"
+)
+
+const (
+	// EscapeChar 单元格中用\转义分隔符, 比如\, \; \[ \] \= \\ 以及保留首尾空格的"\ "
+	EscapeChar = '\\'
+	// Escapable 可以被转义的字符, 其他字符前面的\原样保留, 比如C:\path
+	Escapable = ",;[]=\\ "
+)
+
+// isEscapable text[index]是否是可以被\转义的字符
+func isEscapable(text string, index int) bool {
+	return index < len(text) && strings.IndexByte(Escapable, text[index]) >= 0
+}
+
+// isEscaped text[index]前面是否有奇数个\, 即这个字符被转义
+func isEscaped(text string, index int) bool {
+	count := 0
+	for i := index - 1; i >= 0 && text[i] == EscapeChar; i-- {
+		count++
+	}
+	return count%2 == 1
+}
+
+// scanText 遍历text中没有被转义的字符, 分隔符只在这些字符中查找, f返回false时停止
+func scanText(text string, f func(index int, r rune) bool) {
+	escaped := false
+	for index, r := range text {
+		if escaped {
+			escaped = false
+			continue
+		}
+		if r == EscapeChar && isEscapable(text, index+1) {
+			escaped = true
+			continue
+		}
+		if !f(index, r) {
+			return
+		}
+	}
+}
+
+// unescape 去掉转义用的\, 只在最后解析Str时调用, 之前的拆分都保留转义
+func unescape(text string) string {
+	if strings.IndexByte(text, EscapeChar) < 0 {
+		return text
+	}
+	var builder strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == EscapeChar && isEscapable(text, i+1) {
+			i++
+		}
+		builder.WriteByte(text[i])
+	}
+	return builder.String()
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// trimText 去掉首尾的空白, 保留被转义的空格
+func trimText(text string) string {
+	start, end := 0, len(text)
+	for start < end && isSpace(text[start]) {
+		start++
+	}
+	for end > start && isSpace(text[end-1]) && !(text[end-1] == ' ' && isEscaped(text, end-1)) {
+		end--
+	}
+	return text[start:end]
+}
+
+// compactText 去掉所有空格和换行, 非Str类型和lua劫持的字段保持原来的解析方式
+func compactText(text string) string {
+	text = strings.Replace(text, " ", "", -1)
+	return strings.Replace(text, "\n", "", -1)
+}
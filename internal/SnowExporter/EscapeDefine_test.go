@@ -0,0 +1,104 @@
+package snowExporter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnescape(t *testing.T) {
+	cases := map[string]string{
+		``:              ``,
+		`plain`:         `plain`,
+		`a\,b\;c`:       `a,b;c`,
+		`\[x\]\=y`:      `[x]=y`,
+		`\\`:            `\`,
+		`\\\,`:          `\,`,
+		`C:\path\to`:    `C:\path\to`,
+		`end\`:          `end\`,
+		`\ lead`:        ` lead`,
+		`中文\，测试`:        `中文\，测试`,
+		`say "hi" \n`:   `say "hi" \n`,
+		`x]]y\]\]`:      `x]]y]]`,
+		"tab\\\ttab":    "tab\\\ttab",
+		`\\\\server\\x`: `\\server\x`,
+	}
+	for text, want := range cases {
+		if got := unescape(text); got != want {
+			t.Errorf("unescape(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestTrimText(t *testing.T) {
+	cases := map[string]string{
+		"  a b  ":      "a b",
+		"\n\ta\r\n":    "a",
+		`a\ `:          `a\ `,
+		`a\\ `:         `a\\`,
+		`\ `:           `\ `,
+		"   ":          "",
+		" 中文 ":         "中文",
+		"line1\nline2": "line1\nline2",
+	}
+	for text, want := range cases {
+		if got := trimText(text); got != want {
+			t.Errorf("trimText(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestScanTextSkipsEscaped(t *testing.T) {
+	text := `a\,b,c\\,d`
+	commas := make([]int, 0)
+	scanText(text, func(index int, r rune) bool {
+		if r == ',' {
+			commas = append(commas, index)
+		}
+		return true
+	})
+	if want := []int{4, 8}; !reflect.DeepEqual(commas, want) {
+		t.Errorf("commas in %q at %v, want %v", text, commas, want)
+	}
+}
+
+// parseCellText 按类型解析一个单元格, 没有hook
+func parseCellText(t *testing.T, typeText string, text string) interface{} {
+	t.Helper()
+	headType, defaultValue := ParseType(typeText)
+	header := &Header{workbook: "escape.xlsx", sheet: "s", name: "Text", index: 1, headType: headType, defaultValue: defaultValue}
+	var value interface{}
+	if reason := catchReason(func() { value = header.ParseData(text) }); reason != "" {
+		t.Fatalf("parse %s %q got error: %s", typeText, text, reason)
+	}
+	return value
+}
+
+func TestParseTrickyStrings(t *testing.T) {
+	cases := []struct {
+		typeText string
+		text     string
+		want     interface{}
+	}{
+		{"Str", `say "hi"`, `say "hi"`},
+		{"Str", `C:\path\to\file`, `C:\path\to\file`},
+		{"Str", "line1\nline2\ttab", "line1\nline2\ttab"},
+		{"Str", "  keep inner  spaces  ", "keep inner  spaces"},
+		{"Str", `\ both\ `, ` both `},
+		{"Str", `a]]b[[c`, `a]]b[[c`},
+		{"Str", `[==[x]==]`, `[==[x]==]`},
+		{"Str", "中文，对话😀", "中文，对话😀"},
+		{"Str", `a\,b\;c\=d`, `a,b;c=d`},
+		{"Str", `\\`, `\`},
+		{"List(Str)", `a b, c\,d , e\;f`, []interface{}{"a b", "c,d", "e;f"}},
+		{"List(Str)", `[x\]\]y],[ z ]`, []interface{}{"x]]y", "z"}},
+		{"List(Str)", `"q",中文`, []interface{}{`"q"`, "中文"}},
+		{"Dict(a:Str,b:Int)", `a = x\=y z , b = 2`, map[string]interface{}{"a": "x=y z", "b": 2}},
+		{"Dict(a:Str,b:Int)", `a=\ lead,b=1`, map[string]interface{}{"a": " lead", "b": 1}},
+		{"Dict(a:Str,b:Int)", `a=C:\dir\\,b=3`, map[string]interface{}{"a": `C:\dir\`, "b": 3}},
+	}
+	for _, c := range cases {
+		if got := parseCellText(t, c.typeText, c.text); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parse %s %q = %#v, want %#v", c.typeText, c.text, got, c.want)
+		}
+	}
+}
@@ -1,31 +1,89 @@
 package snowExporter
 
 import (
+	conf "exporterX/DataExporter"
 	"fmt"
-	"log"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/xuri/excelize/v2"
 	lua "github.com/yuin/gopher-lua"
 )
 
+// CellPos 单元格在Excel中的位置, Row和Col从1开始, 0表示未知
+type CellPos struct {
+	Row int
+	Col int
+}
+
+// String 返回Excel中的单元格名称, 如F233, 只知道行时返回行号
+func (c CellPos) String() string {
+	if c.Row <= 0 {
+		return ""
+	}
+	if c.Col <= 0 {
+		return strconv.Itoa(c.Row)
+	}
+	name, err := excelize.CoordinatesToCellName(c.Col, c.Row)
+	if err != nil {
+		return strconv.Itoa(c.Row)
+	}
+	return name
+}
+
 type Header struct {
-	logger       *log.Logger
+	workbook     string
+	sheet        string
 	name         string
 	index        int
 	headType     *HeadType
 	defaultValue interface{}
-	hooker       func(text string) interface{}
+	hooker       func(text string) (interface{}, error)
 	luaState     *lua.LState
 }
 
-func NewHeader(n int, dataName string, name string, index int, headType *HeadType, defaultValue interface{}) *Header {
+// TagSeparator 字段名后面的导出标签, 比如Attack@S只导出到tags包含S的目标, Name@CS导出到C和S
+const TagSeparator = "@"
+
+// SplitTags 分开字段名和导出标签, 每个大写字母是一个标签, 没有标签的字段导出到所有目标
+func SplitTags(name string) (string, string, error) {
+	index := strings.LastIndex(name, TagSeparator)
+	if index < 0 {
+		return name, "", nil
+	}
+	key, tags := name[:index], name[index+len(TagSeparator):]
+	if key == "" || tags == "" {
+		return name, "", fmt.Errorf("bad field name %s, need Name%sTags like Attack%sS", name, TagSeparator, TagSeparator)
+	}
+	for _, c := range tags {
+		if c < 'A' || c > 'Z' {
+			return name, "", fmt.Errorf("bad tag %q in %s, tags are upper case letters like C or S", c, name)
+		}
+	}
+	return key, tags, nil
+}
+
+// MatchTags 没有标签的字段或者导出目标不限制标签时导出, 否则有一个相同的标签才导出
+func MatchTags(fieldTags string, tags []string) bool {
+	if fieldTags == "" || len(tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if tag != "" && strings.Contains(fieldTags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewHeader(n int, dataDef *conf.DataDefine, name string, index int, headType *HeadType, defaultValue interface{}) *Header {
 	key := strings.Replace(name, " ", "", -1)
 	key = strings.Replace(key, "\n", "", -1)
-	hooker := LuaHooker.GetHookHandler(dataName, name)
+	hooker := LuaHooker.GetHookHandler(n, dataDef.Name, name)
 	return &Header{
-		logger:       log.New(os.Stdout, "["+dataName+"]: ", log.Lshortfile),
+		workbook:     dataDef.Excel,
+		sheet:        dataDef.Sheet,
 		name:         key,
 		index:        index,
 		headType:     headType,
@@ -35,8 +93,21 @@ func NewHeader(n int, dataName string, name string, index int, headType *HeadTyp
 	}
 }
 
-func (h *Header) SetLoggerPrefix(p string) {
-	h.logger.SetPrefix(p)
+// parseError 单元格解析失败的原因, 由SnowSingleExporter收集成CellError
+type parseError struct {
+	reason string
+}
+
+func (e *parseError) Error() string {
+	return e.reason
+}
+
+func (h *Header) failf(format string, v ...interface{}) {
+	panic(&parseError{fmt.Sprintf(format, v...)})
+}
+
+func (h *Header) Type() string {
+	return h.headType.Meta
 }
 
 func (h *Header) Key() string {
@@ -47,44 +118,89 @@ func (h *Header) Needed() bool {
 	return h.name != ""
 }
 
+// HasRef 该列是否需要检查对其他表的引用, 被lua劫持的字段不检查
+func (h *Header) HasRef() bool {
+	return h.Needed() && h.hooker == nil && h.headType.HasRef()
+}
+
+// EachRef 遍历value中所有引用其他表的key
+func (h *Header) EachRef(value interface{}, f func(target string, key string)) {
+	eachRef(h.headType, value, f)
+}
+
+func eachRef(headType *HeadType, value interface{}, f func(target string, key string)) {
+	switch headType.MetaType {
+	case RefPrefix:
+		if value != 0 && value != "" {
+			f(headType.RefTo, fmt.Sprint(value))
+		}
+	case ListPrefix:
+		list, _ := value.([]interface{})
+		for _, elem := range list {
+			eachRef(headType.ListIn, elem, f)
+		}
+	case DictPrefix:
+		dict, _ := value.(map[string]interface{})
+		keys := make([]string, 0, len(dict))
+		for k := range dict {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if in, ok := headType.DictIn[k]; ok {
+				eachRef(in, dict[k], f)
+			}
+		}
+	}
+}
+
 func (h *Header) IsExportFlag() bool {
 	return h.name == "ExportTable"
 }
 
+// ParseData 解析单元格的内容, 解析失败时panic, 由调用者加上单元格位置
+// Str保留中间的空白, 其他类型和lua劫持的字段去掉所有空格和换行
 func (h *Header) ParseData(text string) interface{} {
 	if h.name == "" {
 		return nil
 	}
-	text = strings.Replace(text, " ", "", -1)
-	text = strings.Replace(text, "\n", "", -1)
+	if h.hooker != nil {
+		text = compactText(text)
+	}
 	return h.parseByHeadType(text, h.headType, h.defaultValue)
 }
 
 func (h *Header) parseByHeadType(text string, headType *HeadType, defaultValue interface{}) interface{} {
 	if h.hooker != nil {
-		return h.hooker(text)
+		value, err := h.hooker(text)
+		if err != nil {
+			h.failf("%s", err)
+		}
+		return value
 	}
 	switch headType.MetaType {
 	case Nil:
 		return nil
 	case Int:
-		return h.parseInt(text, defaultValue)
+		return h.parseInt(compactText(text), defaultValue)
 	case Float:
-		return h.parseFloat(text, defaultValue)
+		return h.parseFloat(compactText(text), defaultValue)
 	case Str:
 		return h.parseStr(text, defaultValue)
 	case Bool:
-		return h.parseBool(text, defaultValue)
+		return h.parseBool(compactText(text), defaultValue)
 	case ListPrefix:
 		return h.parseList(text, headType)
 	case DictPrefix:
 		return h.parseDict(text, headType)
 	case EnumPrefix:
-		return h.parseEnum(text, headType)
+		return h.parseEnum(compactText(text), headType)
 	case FuncPrefix:
-		return h.parseFunc(text, headType)
+		return h.parseFunc(compactText(text), headType)
+	case RefPrefix:
+		return h.parseRef(compactText(text), headType)
 	default:
-		h.logger.Panicf("Cannot understand metaType %s when meet %s", headType.MetaType, text)
+		h.failf("Cannot understand metaType %s when meet %s", headType.MetaType, text)
 	}
 	return nil
 }
@@ -95,7 +211,7 @@ func (h *Header) parseInt(text string, defaultValue interface{}) int {
 	}
 	value, err := strconv.Atoi(text)
 	if err != nil {
-		h.logger.Panicf("Cannot convert %s to Int, %s", text, err.Error())
+		h.failf("Cannot convert %s to Int, %s", text, err.Error())
 	}
 	return value
 }
@@ -106,16 +222,18 @@ func (h *Header) parseFloat(text string, defaultValue interface{}) float64 {
 	}
 	value, err := strconv.ParseFloat(text, 64)
 	if err != nil {
-		h.logger.Panicf("Cannot convert %s to Float, %s", text, err.Error())
+		h.failf("Cannot convert %s to Float, %s", text, err.Error())
 	}
 	return value
 }
 
+// parseStr 去掉首尾空白后还原转义字符, 中间的空格和换行保留
 func (h *Header) parseStr(text string, defaultValue interface{}) string {
+	text = trimText(text)
 	if text == "" && defaultValue != nil {
 		return defaultValue.(string)
 	}
-	return text
+	return unescape(text)
 }
 
 func (h *Header) parseBool(text string, defaultValue interface{}) bool {
@@ -124,20 +242,34 @@ func (h *Header) parseBool(text string, defaultValue interface{}) bool {
 	}
 	value, err := strconv.ParseBool(text)
 	if err != nil {
-		h.logger.Panicf("Cannot convert %s to Bool, %s", text, err.Error())
+		h.failf("Cannot convert %s to Bool, %s", text, err.Error())
 	}
 	return value
 }
 
+// parseList 用没有转义的,或者;分割, 以[开头时每个[]是一个元素, 元素原样交给下一层解析
 func (h *Header) parseList(text string, headType *HeadType) []interface{} {
 	list := make([]interface{}, 0, 2)
+	text = trimText(text)
 	if text == "" {
 		return list
 	}
 
+	substrings := make([]string, 0, 2)
 	if text[0] != '[' {
-		substrings := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ';' })
+		left := 0
+		scanText(text, func(index int, r rune) bool {
+			if r == ',' || r == ';' {
+				substrings = append(substrings, text[left:index])
+				left = index + 1
+			}
+			return true
+		})
+		substrings = append(substrings, text[left:])
 		for _, substring := range substrings {
+			if trimText(substring) == "" {
+				continue
+			}
 			list = append(list, h.parseByHeadType(substring, headType.ListIn, nil))
 		}
 		return list
@@ -145,8 +277,7 @@ func (h *Header) parseList(text string, headType *HeadType) []interface{} {
 
 	leftIndex := 0
 	num := 0
-	substrings := make([]string, 0, 2)
-	for index, r := range text {
+	scanText(text, func(index int, r rune) bool {
 		if r == '[' {
 			if num == 0 {
 				leftIndex = index
@@ -158,37 +289,36 @@ func (h *Header) parseList(text string, headType *HeadType) []interface{} {
 				substrings = append(substrings, text[leftIndex+1:index])
 			}
 		}
-	}
+		return true
+	})
 	for _, substring := range substrings {
 		list = append(list, h.parseByHeadType(substring, headType.ListIn, nil))
 	}
 	return list
 }
 
+// parseDict 每个没有转义的=前面到上一个,之间是key, 后面到下一个key之前是value
 func (h *Header) parseDict(text string, headType *HeadType) map[string]interface{} {
 	dict := make(map[string]interface{})
+	text = trimText(text)
 	if text == "" {
 		return dict
 	}
 
 	keyIndexes := make([]int, 0, 2)
-	for index, r := range text {
-		if r == '=' {
-			left := index
-			for left > 0 {
-				left--
-				if text[left] == ',' {
-					left++
-					break
-				}
-			}
+	left := 0
+	scanText(text, func(index int, r rune) bool {
+		if r == ',' {
+			left = index + 1
+		} else if r == '=' {
 			keyIndexes = append(keyIndexes, left, index)
 		}
-	}
+		return true
+	})
 
 	kvMap := make(map[string]string)
 	for i := 0; i < len(keyIndexes); i += 2 {
-		key := text[keyIndexes[i]:keyIndexes[i+1]]
+		key := compactText(text[keyIndexes[i]:keyIndexes[i+1]])
 		value := ""
 		if i+2 >= len(keyIndexes) {
 			value = text[keyIndexes[i+1]+1:]
@@ -200,7 +330,7 @@ func (h *Header) parseDict(text string, headType *HeadType) map[string]interface
 
 	for k, v := range kvMap {
 		if _, ok := headType.DictIn[k]; !ok {
-			h.logger.Panicf("key %s not exist in dict %s", k, headType.Meta)
+			h.failf("key %s not exist in dict %s", k, headType.Meta)
 		}
 		dict[k] = h.parseByHeadType(v, headType.DictIn[k], nil)
 	}
@@ -215,10 +345,25 @@ func (h *Header) parseEnum(text string, headType *HeadType) int {
 	if _, ok := headType.EnumIn[text]; ok {
 		return headType.EnumIn[text]
 	}
-	h.logger.Panicf("%s not in Enum %v", text, headType.EnumIn)
+	h.failf("%s not in Enum %v", text, headType.EnumIn)
 	return 0
 }
 
+// parseRef 引用其他表的key, 按被引用的数据的key类型导出成Int或Str, 不填是0或""
+func (h *Header) parseRef(text string, headType *HeadType) interface{} {
+	if headType.RefKey != Int {
+		return text
+	}
+	if text == "" {
+		return 0
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		h.failf("%s is not a key of %s, its key is Int", text, headType.RefTo)
+	}
+	return value
+}
+
 var CoefficientsOfUnaryQuadraticExpressionFormat = `
 	local expression = function(x) return %s end
 	local a, b, c = 0, 0, 0
@@ -253,16 +398,16 @@ func (h *Header) parseFunc(text string, headType *HeadType) interface{} {
 			group := group.([]interface{})
 			first, err := strconv.ParseFloat(group[0].(string), 64)
 			if err != nil {
-				h.logger.Panicf("%s parse failed %s", text, err)
+				h.failf("%s parse failed %s", text, err)
 			}
 			second, err := strconv.ParseFloat(group[1].(string), 64)
 			if err != nil {
-				h.logger.Panicf("%s parse failed %s", text, err)
+				h.failf("%s parse failed %s", text, err)
 			}
 			expression := group[2].(string)
 			err = h.luaState.DoString(fmt.Sprintf(CoefficientsOfUnaryQuadraticExpressionFormat, expression))
 			if err != nil {
-				h.logger.Panicf("%s run lua failed %s", text, err)
+				h.failf("%s run lua failed %s", text, err)
 			}
 			a, b, c := h.luaState.Get(-3), h.luaState.Get(-2), h.luaState.Get(-1)
 			af, bf, cf := float64(lua.LVAsNumber(a)), float64(lua.LVAsNumber(b)), float64(lua.LVAsNumber(c))
@@ -294,7 +439,7 @@ func (h *Header) parseFunc(text string, headType *HeadType) interface{} {
 		expression := text[len(FuncFunc1)+1:]
 		err := h.luaState.DoString(fmt.Sprintf(CoefficientsOfUnaryQuadraticExpressionFormat, expression))
 		if err != nil {
-			h.logger.Panicf("%s run lua failed %s", text, err)
+			h.failf("%s run lua failed %s", text, err)
 		}
 		a, b, c := h.luaState.Get(-3), h.luaState.Get(-2), h.luaState.Get(-1)
 		h.luaState.Pop(3)
@@ -307,7 +452,7 @@ func (h *Header) parseFunc(text string, headType *HeadType) interface{} {
 
 	value, err := strconv.ParseFloat(text, 64)
 	if err != nil {
-		h.logger.Panicf("%s parse to float64 failed %s", text, err)
+		h.failf("%s parse to float64 failed %s", text, err)
 	}
 	return value
 }
@@ -3,11 +3,14 @@ package snowExporter
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,44 +19,207 @@ import (
 	"github.com/yuin/gopher-lua/parse"
 )
 
-const HookLuaPath = "./hook/"
+const (
+	// GlobalProcessLua hook目录中处理缓存数据的文件
+	GlobalProcessLua = "GlobalProcess.lua"
+	// RowHookName 行hook的函数名是[数据名]_Row
+	RowHookName = "Row"
+)
 
 type LuaHookManager struct {
-	logger   *log.Logger
-	luaLock  sync.Mutex
-	luaState *lua.LState
-	hookMap  sync.Map
+	logger  *log.Logger
+	hookDir string
+	// 每个worker一个lua虚拟机, 都加载了所有hook, 同一个worker的hook依次执行, 不同worker的hook并行执行
+	hookStates []*lua.LState
+	hooks      []hookFile
+	// hookMap 有hook文件的数据名, 每次加载hook时重新创建
+	hookMap *sync.Map
+	// baseGlobals 加载hook之前worker虚拟机中的全局变量, 重新加载hook时恢复成这些, 去掉删除了的hook定义的函数
+	baseGlobals map[string]lua.LValue
+	// GlobalProcess.lua使用单独的虚拟机, 所有worker都会给它发送数据, 需要加锁
+	globalLock  sync.Mutex
+	globalState *lua.LState
+	builtins    map[string]bool
+	// 缓存给GlobalProcess.lua的数据, exporter.data查询
+	cacheLock  sync.RWMutex
+	cachedData map[string]*cachedTable
+	// 这次导出中收到的缓存数据, 执行处理之后清空
+	received map[string]bool
+	// GlobalProcess.lua注册的处理, 已经按依赖排序; processed是上次执行输出的数据
+	processors []*processor
+	processed  map[string]*lua.LTable
+	// hook只能写writeDirs中的文件, 导出时是每个导出目标的staging目录, unsafe为true时不限制
+	writeDirs []string
+	unsafe    bool
+}
+
+// hookFile 编译好的hook文件, 按文件名顺序加载到每个worker的虚拟机
+type hookFile struct {
+	path  string
+	proto *lua.FunctionProto
 }
 
 func NewLuaHookManager() *LuaHookManager {
 	logger := log.New(os.Stdout, "LuaHookManager:", log.Lshortfile)
-	return &LuaHookManager{
-		logger:   logger,
-		luaState: lua.NewState(),
+	globalState := lua.NewState()
+	// 记录lua自带的模块, 重新加载hook时只清理require过的hook模块
+	builtins := make(map[string]bool)
+	globalState.GetField(globalState.Get(lua.RegistryIndex), "_LOADED").(*lua.LTable).ForEach(func(k, v lua.LValue) {
+		builtins[lua.LVAsString(k)] = true
+	})
+	l := &LuaHookManager{
+		logger:      logger,
+		hookMap:     &sync.Map{},
+		globalState: globalState,
+		builtins:    builtins,
+		cachedData:  make(map[string]*cachedTable),
+		received:    make(map[string]bool),
 	}
+	l.openLibs(globalState, true)
+	return l
 }
 
+// PrepareHookFunction 编译hook目录中的所有lua文件, 并加载到已有的worker虚拟机
 func (l *LuaHookManager) PrepareHookFunction() {
-	files, err := ioutil.ReadDir(HookLuaPath)
+	files, err := ioutil.ReadDir(l.hookDir)
 	if err != nil {
-		l.logger.Panicf("ReadDir %s failed error: %s", HookLuaPath, err)
+		l.logger.Panicf("ReadDir %s failed error: %s", l.hookDir, err)
 	}
 
+	hooks := make([]hookFile, 0, len(files))
+	hookMap := &sync.Map{}
 	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".lua") {
-			path := path.Join(HookLuaPath, file.Name())
+		// GlobalProcess.lua只在单独的虚拟机中执行
+		if strings.HasSuffix(file.Name(), ".lua") && file.Name() != GlobalProcessLua {
+			path := path.Join(l.hookDir, file.Name())
 			proto, err := l.CompileLuaFile(path)
 			if err != nil {
 				l.logger.Panicf("%s compile got error: %s", path, err)
 			}
-			l.luaState.Push(l.luaState.NewFunctionFromProto(proto))
-			err = l.luaState.PCall(0, lua.MultRet, nil)
-			if err != nil {
-				l.logger.Panicf("%s pcall got error: %s", path, err)
-			}
-			l.hookMap.Store(strings.TrimSuffix(file.Name(), ".lua"), proto)
+			hooks = append(hooks, hookFile{path, proto})
+			hookMap.Store(strings.TrimSuffix(file.Name(), ".lua"), proto)
 		}
 	}
+	l.hooks = hooks
+	l.hookMap = hookMap
+	for _, state := range l.hookStates {
+		l.resetGlobals(state)
+		l.loadHooks(state)
+	}
+}
+
+// SetHookDir 设置hook目录并编译其中的hook, 所有虚拟机中require都先从hook目录查找模块
+func (l *LuaHookManager) SetHookDir(dir string) {
+	l.hookDir = dir
+	l.globalLock.Lock()
+	l.setPackagePath(l.globalState)
+	l.globalLock.Unlock()
+	l.PrepareHookFunction()
+}
+
+// GlobalProcessPath hook目录中GlobalProcess.lua的路径
+func (l *LuaHookManager) GlobalProcessPath() string {
+	return path.Join(l.hookDir, GlobalProcessLua)
+}
+
+// setPackagePath require按hook目录中的文件或者子目录的init.lua查找模块, 然后是lua默认的路径
+func (l *LuaHookManager) setPackagePath(state *lua.LState) {
+	pkg := state.GetGlobal("package").(*lua.LTable)
+	hookPath := path.Join(l.hookDir, "?.lua") + ";" + path.Join(l.hookDir, "?", "init.lua")
+	state.SetField(pkg, "path", lua.LString(hookPath+";"+lua.LVAsString(state.GetField(pkg, "path"))))
+}
+
+// SetHookStates 在每个worker的虚拟机中加载所有hook, GetHookHandler按worker的下标取对应虚拟机中的函数
+func (l *LuaHookManager) SetHookStates(states []*lua.LState) {
+	l.hookStates = states
+	for i, state := range states {
+		l.openLibs(state, false)
+		l.setPackagePath(state)
+		// 所有worker虚拟机加载hook之前的全局变量都相同
+		if i == 0 {
+			l.baseGlobals = make(map[string]lua.LValue)
+			state.G.Global.ForEach(func(k, v lua.LValue) {
+				l.baseGlobals[lua.LVAsString(k)] = v
+			})
+		}
+		l.loadHooks(state)
+	}
+}
+
+// resetGlobals 去掉hook定义的全局变量, 恢复被hook覆盖的全局变量
+func (l *LuaHookManager) resetGlobals(state *lua.LState) {
+	globals := state.G.Global
+	names := make([]lua.LValue, 0, 16)
+	globals.ForEach(func(k, v lua.LValue) {
+		if _, ok := l.baseGlobals[lua.LVAsString(k)]; !ok {
+			names = append(names, k)
+		}
+	})
+	for _, name := range names {
+		globals.RawSet(name, lua.LNil)
+	}
+	for name, value := range l.baseGlobals {
+		globals.RawSetString(name, value)
+	}
+}
+
+func (l *LuaHookManager) loadHooks(state *lua.LState) {
+	for _, hook := range l.hooks {
+		state.Push(state.NewFunctionFromProto(hook.proto))
+		if err := state.PCall(0, lua.MultRet, nil); err != nil {
+			l.logger.Panicf("%s pcall got error: %s", hook.path, err)
+		}
+	}
+}
+
+// HookFiles 返回影响hook结果的文件: 所有hook文件都加载到同一个虚拟机, 共享全局变量,
+// 所以是hook目录中所有的hook文件, 以及hook子目录中可能被require的lua模块
+func (l *LuaHookManager) HookFiles() []string {
+	files := make([]string, 0, len(l.hooks))
+	for _, hook := range l.hooks {
+		files = append(files, hook.path)
+	}
+	modules := make([]string, 0, 4)
+	filepath.Walk(l.hookDir, func(filePath string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(filePath, ".lua") && filepath.Dir(filePath) != filepath.Clean(l.hookDir) {
+			modules = append(modules, filepath.ToSlash(filePath))
+		}
+		return nil
+	})
+	sort.Strings(modules)
+	return append(files, modules...)
+}
+
+// ReloadHookFunction watch模式下hook文件变化时重新加载所有hook,
+// 每个虚拟机中已经require过的模块也会重新加载, 虚拟机不变, 但是hook定义的全局变量会先清理
+func (l *LuaHookManager) ReloadHookFunction() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	for _, state := range l.hookStates {
+		l.clearModules(state)
+	}
+	l.globalLock.Lock()
+	l.clearModules(l.globalState)
+	l.globalLock.Unlock()
+	l.PrepareHookFunction()
+	return nil
+}
+
+// clearModules 清理虚拟机中require过的模块, 下次require时重新加载
+func (l *LuaHookManager) clearModules(state *lua.LState) {
+	loaded := state.GetField(state.Get(lua.RegistryIndex), "_LOADED").(*lua.LTable)
+	modules := make([]lua.LValue, 0, 4)
+	loaded.ForEach(func(k, v lua.LValue) {
+		if !l.builtins[lua.LVAsString(k)] {
+			modules = append(modules, k)
+		}
+	})
+	for _, module := range modules {
+		loaded.RawSet(module, lua.LNil)
+	}
 }
 
 func (l *LuaHookManager) ConvertToLuaValue(element interface{}) lua.LValue {
@@ -191,60 +357,128 @@ func (m *LuaHookManager) ConvertLuaValue(functionName string, text string, value
 	}
 }
 
-func (m *LuaHookManager) GetHookHandler(dataName string, keyName string) func(text string) interface{} {
+// GetHookHandler 返回第n个worker的虚拟机中的hook函数, 只能在这个worker中调用
+func (m *LuaHookManager) GetHookHandler(n int, dataName string, keyName string) func(text string) (interface{}, error) {
 	if _, ok := m.hookMap.Load(dataName); !ok {
 		return nil
 	}
 
 	functionName := dataName + "_" + keyName
-	luaFunc := m.luaState.GetGlobal(functionName)
+	luaState := m.hookStates[n]
+	luaFunc := luaState.GetGlobal(functionName)
 	if luaFunc == lua.LNil {
 		return nil
 	}
 
-	return func(text string) interface{} {
-		m.luaLock.Lock()
-		defer m.luaLock.Unlock()
-		err := m.luaState.CallByParam(lua.P{
+	return func(text string) (interface{}, error) {
+		err := luaState.CallByParam(lua.P{
 			Fn:      luaFunc,
 			NRet:    1,
 			Protect: true,
 		}, lua.LString(text))
 		if err != nil {
-			m.logger.Panicf("call lua %s with %s got error: %s", functionName, text, err)
+			if hookErr := hookErrorOf(err); hookErr != nil {
+				return nil, hookErr
+			}
+			return nil, fmt.Errorf("call lua %s got error: %s", functionName, luaErrorMessage(err))
+		}
+		ret := luaState.Get(-1)
+		luaState.Pop(1)
+
+		return m.ConvertLuaValue(functionName, text, ret), nil
+	}
+}
+
+// GetRowHookHandler 返回第n个worker的虚拟机中的行hook, 参数是一行解析后的数据, hook返回nil时去掉这一行
+func (m *LuaHookManager) GetRowHookHandler(n int, dataName string) func(row map[string]interface{}) (map[string]interface{}, error) {
+	if _, ok := m.hookMap.Load(dataName); !ok {
+		return nil
+	}
+
+	functionName := dataName + "_" + RowHookName
+	luaState := m.hookStates[n]
+	luaFunc := luaState.GetGlobal(functionName)
+	if luaFunc == lua.LNil {
+		return nil
+	}
+
+	return func(row map[string]interface{}) (map[string]interface{}, error) {
+		rowTable := luaState.NewTable()
+		for key, value := range row {
+			if value != nil {
+				rowTable.RawSetString(key, m.ConvertToLuaValue(value))
+			}
+		}
+		err := luaState.CallByParam(lua.P{
+			Fn:      luaFunc,
+			NRet:    1,
+			Protect: true,
+		}, rowTable)
+		if err != nil {
+			if hookErr := hookErrorOf(err); hookErr != nil {
+				return nil, hookErr
+			}
+			return nil, fmt.Errorf("call lua %s got error: %s", functionName, luaErrorMessage(err))
 		}
-		ret := m.luaState.Get(-1)
-		m.luaState.Pop(1)
+		ret := luaState.Get(-1)
+		luaState.Pop(1)
 
-		return m.ConvertLuaValue(functionName, text, ret)
+		if ret == lua.LNil {
+			return nil, nil
+		}
+		table, ok := ret.(*lua.LTable)
+		if !ok {
+			return nil, fmt.Errorf("lua %s return %s, need a table or nil", functionName, ret.Type())
+		}
+		// 空表也是一行数据, 不能按ConvertLuaValue转换成列表
+		result := make(map[string]interface{})
+		table.ForEach(func(k lua.LValue, v lua.LValue) {
+			result[lua.LVAsString(k)] = m.ConvertLuaValue(functionName, lua.LVAsString(k), v)
+		})
+		return result, nil
 	}
 }
 
+// luaErrorMessage 只取lua错误的内容, 不带调用栈
+func luaErrorMessage(err error) string {
+	if apiErr, ok := err.(*lua.ApiError); ok && apiErr.Object != nil {
+		return apiErr.Object.String()
+	}
+	return err.Error()
+}
+
+// InitGlobalProcess 执行GlobalProcess.lua, 返回需要缓存的数据: GlobalCacheDataList和所有处理的输入输出
 func (m *LuaHookManager) InitGlobalProcess() []string {
-	globalProcessLua := "./hook/GlobalProcess.lua"
+	m.processors = nil
+	globalProcessLua := m.GlobalProcessPath()
 	if _, err := os.Stat(globalProcessLua); errors.Is(err, os.ErrNotExist) {
 		return nil
 	}
-	if err := m.luaState.DoFile(globalProcessLua); err != nil {
+	m.globalState.SetGlobal(ProcessorRegister, m.globalState.NewFunction(m.registerProcessor))
+	if err := m.globalState.DoFile(globalProcessLua); err != nil {
 		m.logger.Panicf("%s compile got error: %s", globalProcessLua, err)
 	}
+	processors, err := sortProcessors(m.processors)
+	if err != nil {
+		m.logger.Panicf("%s got error: %s", globalProcessLua, err)
+	}
+	m.processors = processors
 
-	// proto, err := LuaHooker.CompileLuaFile(globalProcessLua)
-	// if err != nil {
-	// 	m.logger.Panicf("%s compile got error: %s", globalProcessLua, err)
-	// }
-	// m.luaState.Push(m.luaState.NewFunctionFromProto(proto))
-	err := m.luaState.CallByParam(lua.P{
-		Fn:      m.luaState.GetGlobal("GlobalCacheDataList"),
+	cacheNameList := m.processorData()
+	cacheListFunc := m.globalState.GetGlobal("GlobalCacheDataList")
+	if cacheListFunc == lua.LNil {
+		return cacheNameList
+	}
+	err = m.globalState.CallByParam(lua.P{
+		Fn:      cacheListFunc,
 		NRet:    1,
 		Protect: true,
 	})
 	if err != nil {
 		m.logger.Panicf("%s call GlobalCacheDataList got error: %s", globalProcessLua, err)
 	}
-	cacheList := m.luaState.Get(-1).(*lua.LTable)
-	m.luaState.Pop(1)
-	cacheNameList := make([]string, 0, m.luaState.ObjLen(cacheList))
+	cacheList := m.globalState.Get(-1).(*lua.LTable)
+	m.globalState.Pop(1)
 	cacheList.ForEach(func(k, v lua.LValue) {
 		cacheNameList = append(cacheNameList, lua.LVAsString(v))
 	})
@@ -252,39 +486,69 @@ func (m *LuaHookManager) InitGlobalProcess() []string {
 }
 
 func (m *LuaHookManager) GlobalProcessReceiveData(name string, data map[string]interface{}) {
-	ltData := m.MapToTable(data)
-	m.luaLock.Lock()
-	defer m.luaLock.Unlock()
-	err := m.luaState.CallByParam(lua.P{
-		Fn:      m.luaState.GetGlobal("ReceiveCacheData"),
+	m.cacheLock.Lock()
+	m.cachedData[name] = &cachedTable{data}
+	m.received[name] = true
+	m.cacheLock.Unlock()
+	m.globalLock.Lock()
+	defer m.globalLock.Unlock()
+	receiveFunc := m.globalState.GetGlobal("ReceiveCacheData")
+	if receiveFunc == lua.LNil {
+		return
+	}
+	err := m.globalState.CallByParam(lua.P{
+		Fn:      receiveFunc,
 		NRet:    0,
 		Protect: true,
-	}, lua.LString(name), ltData)
+	}, lua.LString(name), m.MapToTable(data))
 	if err != nil {
 		m.logger.Panicf("globalProcessLua call ReceiveCacheData got error: %s", err)
 	}
 }
 
-func (m *LuaHookManager) GlobalProcessCacheData() {
-	m.luaLock.Lock()
-	defer m.luaLock.Unlock()
+// GlobalProcessCacheData 按顺序执行所有处理, 再执行ProcessCacheData, exporter.error抛出的错误返回*HookError
+func (m *LuaHookManager) GlobalProcessCacheData() error {
+	m.globalLock.Lock()
+	defer m.globalLock.Unlock()
 
-	err := m.luaState.CallByParam(lua.P{
-		Fn:      m.luaState.GetGlobal("ProcessCacheData"),
+	processed, err := m.runProcessors()
+	if err != nil {
+		return err
+	}
+	m.processed = processed
+	processFunc := m.globalState.GetGlobal("ProcessCacheData")
+	if processFunc == lua.LNil {
+		return nil
+	}
+	err = m.globalState.CallByParam(lua.P{
+		Fn:      processFunc,
 		NRet:    0,
 		Protect: true,
 	})
 	if err != nil {
-		m.logger.Panicf("globalProcessLua call ProcessCacheData got error: %s", err)
+		if hookErr := hookErrorOf(err); hookErr != nil {
+			return hookErr
+		}
+		return fmt.Errorf("globalProcessLua call ProcessCacheData got error: %s", err)
 	}
+	return nil
 }
 
+// GlobalProcessGetChangedData 处理输出的数据和GetChangedData返回的数据, 同一个数据以GetChangedData为准
 func (m *LuaHookManager) GlobalProcessGetChangedData() map[string]map[string]interface{} {
-	m.luaLock.Lock()
-	defer m.luaLock.Unlock()
+	m.globalLock.Lock()
+	defer m.globalLock.Unlock()
 
-	err := m.luaState.CallByParam(lua.P{
-		Fn:      m.luaState.GetGlobal("GetChangedData"),
+	dataName2MapData := make(map[string]map[string]interface{})
+	for name, data := range m.processed {
+		dataName2MapData[name] = m.tableToData(name, data)
+	}
+	changedFunc := m.globalState.GetGlobal("GetChangedData")
+	if changedFunc == lua.LNil {
+		return dataName2MapData
+	}
+	err := m.globalState.CallByParam(lua.P{
+		Fn:      changedFunc,
 		NRet:    1,
 		Protect: true,
 	})
@@ -292,17 +556,21 @@ func (m *LuaHookManager) GlobalProcessGetChangedData() map[string]map[string]int
 		m.logger.Panicf("globalProcessLua call ProcessCacheData got error: %s", err)
 	}
 
-	changedData := m.luaState.Get(-1).(*lua.LTable)
-	m.luaState.Pop(1)
+	changedData := m.globalState.Get(-1).(*lua.LTable)
+	m.globalState.Pop(1)
 
-	dataName2MapData := make(map[string]map[string]interface{})
 	changedData.ForEach(func(name, data lua.LValue) {
-		mapData := make(map[string]interface{})
-		data.(*lua.LTable).ForEach(func(k, row lua.LValue) {
-			r := m.ConvertLuaValue(lua.LVAsString(name), lua.LVAsString(k), row)
-			mapData[lua.LVAsString(k)] = r.(map[string]interface{})
-		})
-		dataName2MapData[lua.LVAsString(name)] = mapData
+		dataName2MapData[lua.LVAsString(name)] = m.tableToData(lua.LVAsString(name), data.(*lua.LTable))
 	})
 	return dataName2MapData
 }
+
+// tableToData 把lua中一个数据的所有行转换回来, 每一行都是map
+func (m *LuaHookManager) tableToData(name string, data *lua.LTable) map[string]interface{} {
+	mapData := make(map[string]interface{})
+	data.ForEach(func(k, row lua.LValue) {
+		r := m.ConvertLuaValue(name, lua.LVAsString(k), row)
+		mapData[lua.LVAsString(k)] = r.(map[string]interface{})
+	})
+	return mapData
+}
@@ -0,0 +1,67 @@
+package snowExporter
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func writeHook(t *testing.T, dir string, name string, source string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path.Join(dir, name), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadRemovesDeletedHooks(t *testing.T) {
+	dir := t.TempDir()
+	writeHook(t, dir, "MonsterData.lua", `
+function MonsterData_Name(text) return text .. "!" end
+function tostring(v) return "hooked" end
+`)
+	writeHook(t, dir, "ItemData.lua", `function ItemData_Name(text) return text end`)
+
+	hooker := NewLuaHookManager()
+	state := lua.NewState()
+	defer state.Close()
+	hooker.SetHookStates([]*lua.LState{state})
+	hooker.SetHookDir(dir)
+	if hooker.GetHookHandler(0, "MonsterData", "Name") == nil {
+		t.Fatal("MonsterData_Name is not loaded")
+	}
+
+	// MonsterData.lua改名之后不再是MonsterData的hook, 删除的ItemData.lua也不能再生效
+	if err := os.Rename(path.Join(dir, "MonsterData.lua"), path.Join(dir, "Shared.lua")); err != nil {
+		t.Fatal(err)
+	}
+	writeHook(t, dir, "Shared.lua", `function Shared_Name(text) return text end`)
+	if err := os.Remove(path.Join(dir, "ItemData.lua")); err != nil {
+		t.Fatal(err)
+	}
+	if err := hooker.ReloadHookFunction(); err != nil {
+		t.Fatalf("reload got error: %s", err)
+	}
+
+	for _, name := range []string{"MonsterData", "ItemData"} {
+		if _, ok := hooker.hookMap.Load(name); ok {
+			t.Errorf("%s still has a hook file after reload", name)
+		}
+		if hooker.GetHookHandler(0, name, "Name") != nil {
+			t.Errorf("%s_Name still works after reload", name)
+		}
+	}
+	for _, name := range []string{"MonsterData_Name", "ItemData_Name"} {
+		if state.GetGlobal(name) != lua.LNil {
+			t.Errorf("global %s is not cleared after reload", name)
+		}
+	}
+	if err := state.DoString(`assert(tostring(1) == "1")`); err != nil {
+		t.Errorf("tostring overwritten by a deleted hook is not restored: %s", err)
+	}
+	if hooker.GetHookHandler(0, "Shared", "Name") == nil {
+		t.Error("Shared_Name is not loaded after reload")
+	}
+}
@@ -0,0 +1,369 @@
+package snowExporter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// LuaLibName hook中require这个名字得到exporter模块
+const LuaLibName = "exporter"
+
+// HookError hook中exporter.error抛出的错误, 字段hook中总是当前的单元格, 行hook中由Field确定这一行的单元格,
+// GlobalProcess.lua中由Data, Key, Field确定单元格, Field为空时是这一行的key
+type HookError struct {
+	Message string
+	Data    string
+	Key     string
+	Field   string
+}
+
+func (e *HookError) Error() string {
+	return e.Message
+}
+
+// hookErrorOf 取出lua错误中exporter.error抛出的HookError
+func hookErrorOf(err error) *HookError {
+	if apiErr, ok := err.(*lua.ApiError); ok {
+		if ud, ok := apiErr.Object.(*lua.LUserData); ok {
+			if hookErr, ok := ud.Value.(*HookError); ok {
+				return hookErr
+			}
+		}
+	}
+	return nil
+}
+
+// cachedTable GlobalProcess缓存的一份数据, 重新导出后是新的cachedTable
+type cachedTable struct {
+	data map[string]interface{}
+}
+
+// openLibs 在虚拟机中预加载exporter模块, 并限制os和io, global为true时是GlobalProcess.lua的虚拟机
+func (l *LuaHookManager) openLibs(state *lua.LState, global bool) {
+	state.PreloadModule(LuaLibName, l.loadLib(global))
+	l.sandbox(state)
+}
+
+func (l *LuaHookManager) loadLib(global bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		// 每个虚拟机只转换一次缓存的数据, 同一个虚拟机中多次查询返回同一个表
+		converted := make(map[string]*lua.LTable)
+		versions := make(map[string]*cachedTable)
+		mod := L.NewTable()
+		L.SetFuncs(mod, map[string]lua.LGFunction{
+			"split":       luaSplit,
+			"trim":        luaTrim,
+			"tonumber":    luaToNumber,
+			"deepcopy":    luaDeepCopy,
+			"dump":        luaDump,
+			"sortedpairs": luaSortedPairs,
+			"error":       luaError,
+			"outdir":      l.luaOutDir,
+			"data": func(L *lua.LState) int {
+				name := L.CheckString(1)
+				// hook在worker中并行执行, 能查到哪些数据取决于导出顺序, 只在所有数据导出之后的GlobalProcess.lua中可以使用
+				if !global {
+					L.RaiseError("exporter.data(%q) can only be used in %s, hooks run in parallel and may see unfinished data", name, GlobalProcessLua)
+				}
+				l.cacheLock.RLock()
+				cached, ok := l.cachedData[name]
+				l.cacheLock.RUnlock()
+				if !ok {
+					L.RaiseError("data %s is not cached, add it to GlobalCacheDataList in GlobalProcess.lua", name)
+				}
+				if versions[name] != cached {
+					converted[name] = l.MapToTable(cached.data)
+					versions[name] = cached
+				}
+				L.Push(converted[name])
+				return 1
+			},
+		})
+		L.Push(mod)
+		return 1
+	}
+}
+
+// luaOutDir exporter.outdir(index) 这次导出中outputs[index]的staging目录, index默认是1, hook只能写这些目录
+func (l *LuaHookManager) luaOutDir(L *lua.LState) int {
+	index := L.OptInt(1, 1)
+	if index < 1 || index > len(l.writeDirs) {
+		L.RaiseError("exporter.outdir(%d) is not an output being exported", index)
+	}
+	L.Push(lua.LString(l.writeDirs[index-1]))
+	return 1
+}
+
+// luaSplit exporter.split(text, sep) 按sep分割, sep默认是",", 不使用模式匹配
+func luaSplit(L *lua.LState) int {
+	text := L.CheckString(1)
+	sep := L.OptString(2, ",")
+	if sep == "" {
+		L.ArgError(2, "separator is empty")
+	}
+	result := L.NewTable()
+	for _, part := range strings.Split(text, sep) {
+		result.Append(lua.LString(part))
+	}
+	L.Push(result)
+	return 1
+}
+
+// luaTrim exporter.trim(text) 去掉首尾的空白
+func luaTrim(L *lua.LState) int {
+	L.Push(lua.LString(strings.TrimSpace(L.CheckString(1))))
+	return 1
+}
+
+// luaToNumber exporter.tonumber(text) 不是数字时报错, 而不是返回nil
+func luaToNumber(L *lua.LState) int {
+	value := L.CheckAny(1)
+	if number, ok := value.(lua.LNumber); ok {
+		L.Push(number)
+		return 1
+	}
+	text := strings.TrimSpace(lua.LVAsString(value))
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		L.RaiseError("%q is not a number", lua.LVAsString(value))
+	}
+	L.Push(lua.LNumber(number))
+	return 1
+}
+
+// luaDeepCopy exporter.deepcopy(value) 复制表和其中所有的表, 元表不复制
+func luaDeepCopy(L *lua.LState) int {
+	copied := make(map[*lua.LTable]*lua.LTable)
+	var deepCopy func(value lua.LValue) lua.LValue
+	deepCopy = func(value lua.LValue) lua.LValue {
+		table, ok := value.(*lua.LTable)
+		if !ok {
+			return value
+		}
+		if result, exist := copied[table]; exist {
+			return result
+		}
+		result := L.NewTable()
+		copied[table] = result
+		table.ForEach(func(k, v lua.LValue) {
+			result.RawSet(deepCopy(k), deepCopy(v))
+		})
+		result.Metatable = table.Metatable
+		return result
+	}
+	L.Push(deepCopy(L.CheckAny(1)))
+	return 1
+}
+
+// sortedKeys 表的key排序, 数字在前按大小, 字符串在后按字典序, 其他类型按tostring
+func sortedKeys(table *lua.LTable) []lua.LValue {
+	keys := make([]lua.LValue, 0, table.Len())
+	table.ForEach(func(k, v lua.LValue) {
+		keys = append(keys, k)
+	})
+	rank := func(v lua.LValue) int {
+		switch v.(type) {
+		case lua.LNumber:
+			return 0
+		case lua.LString:
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		ri, rj := rank(keys[i]), rank(keys[j])
+		if ri != rj {
+			return ri < rj
+		}
+		if ri == 0 {
+			return keys[i].(lua.LNumber) < keys[j].(lua.LNumber)
+		}
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// luaDump exporter.dump(value) 转换成一行便于阅读的字符串, 表的key排序, 每次结果相同
+func luaDump(L *lua.LState) int {
+	var buffer strings.Builder
+	visiting := make(map[*lua.LTable]bool)
+	var dump func(value lua.LValue)
+	dump = func(value lua.LValue) {
+		switch v := value.(type) {
+		case lua.LString:
+			buffer.WriteString(strconv.Quote(string(v)))
+		case *lua.LTable:
+			if visiting[v] {
+				buffer.WriteString("<cycle>")
+				return
+			}
+			visiting[v] = true
+			buffer.WriteString("{")
+			for i, key := range sortedKeys(v) {
+				if i > 0 {
+					buffer.WriteString(", ")
+				}
+				buffer.WriteString("[")
+				dump(key)
+				buffer.WriteString("] = ")
+				dump(v.RawGet(key))
+			}
+			buffer.WriteString("}")
+			delete(visiting, v)
+		default:
+			buffer.WriteString(value.String())
+		}
+	}
+	dump(L.CheckAny(1))
+	L.Push(lua.LString(buffer.String()))
+	return 1
+}
+
+// luaSortedPairs exporter.sortedpairs(t) 和pairs一样使用, 按key排序遍历
+func luaSortedPairs(L *lua.LState) int {
+	table := L.CheckTable(1)
+	keys := sortedKeys(table)
+	i := 0
+	L.Push(L.NewFunction(func(L *lua.LState) int {
+		if i >= len(keys) {
+			L.Push(lua.LNil)
+			return 1
+		}
+		key := keys[i]
+		i++
+		L.Push(key)
+		L.Push(table.RawGet(key))
+		return 2
+	}))
+	L.Push(table)
+	L.Push(lua.LNil)
+	return 3
+}
+
+// luaError exporter.error(message, data, key, field) 报告带单元格位置的错误
+func luaError(L *lua.LState) int {
+	ud := L.NewUserData()
+	ud.Value = &HookError{
+		Message: L.CheckString(1),
+		Data:    L.OptString(2, ""),
+		Key:     lua.LVAsString(L.Get(3)),
+		Field:   L.OptString(4, ""),
+	}
+	L.Error(ud, 1)
+	return 0
+}
+
+// sandbox 没有配置hook_unsafe时禁止os.execute, io.popen和写staging目录之外的文件
+func (l *LuaHookManager) sandbox(state *lua.LState) {
+	osLib := state.GetGlobal("os").(*lua.LTable)
+	ioLib := state.GetGlobal("io").(*lua.LTable)
+	l.guard(state, osLib, "os", "execute", func(L *lua.LState) {
+		l.deny(L, "os.execute")
+	})
+	l.guard(state, ioLib, "io", "popen", func(L *lua.LState) {
+		l.deny(L, "io.popen")
+	})
+	l.guard(state, ioLib, "io", "open", func(L *lua.LState) {
+		if strings.ContainsAny(L.OptString(2, "r"), "wa+") {
+			l.checkWrite(L, "io.open", L.CheckString(1))
+		}
+	})
+	l.guard(state, ioLib, "io", "output", func(L *lua.LState) {
+		if filePath, ok := L.Get(1).(lua.LString); ok {
+			l.checkWrite(L, "io.output", string(filePath))
+		}
+	})
+	l.guard(state, osLib, "os", "remove", func(L *lua.LState) {
+		l.checkWrite(L, "os.remove", L.CheckString(1))
+	})
+	l.guard(state, osLib, "os", "rename", func(L *lua.LState) {
+		l.checkWrite(L, "os.rename", L.CheckString(1))
+		l.checkWrite(L, "os.rename", L.CheckString(2))
+	})
+}
+
+// guard 替换lib中的函数, 先执行check, check没有报错时再调用原来的函数
+func (l *LuaHookManager) guard(state *lua.LState, lib *lua.LTable, libName string, name string, check func(L *lua.LState)) {
+	origin, ok := lib.RawGetString(name).(*lua.LFunction)
+	if !ok {
+		l.logger.Panicf("lua %s.%s not found", libName, name)
+	}
+	state.SetField(lib, name, state.NewFunction(func(L *lua.LState) int {
+		check(L)
+		top := L.GetTop()
+		L.Push(origin)
+		for i := 1; i <= top; i++ {
+			L.Push(L.Get(i))
+		}
+		L.Call(top, lua.MultRet)
+		return L.GetTop() - top
+	}))
+}
+
+func (l *LuaHookManager) deny(L *lua.LState, name string) {
+	if !l.unsafe {
+		L.RaiseError("%s is disabled in hooks, set hook_unsafe to true in the config to allow it", name)
+	}
+}
+
+// checkWrite 只允许写staging目录中的文件, 路径中的符号链接解析之后再检查
+func (l *LuaHookManager) checkWrite(L *lua.LState, name string, filePath string) {
+	if l.unsafe {
+		return
+	}
+	if resolved, err := resolvePath(filePath); err == nil {
+		for _, dir := range l.writeDirs {
+			rel, err := filepath.Rel(dir, resolved)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return
+			}
+		}
+	}
+	L.RaiseError("%s cannot write %s outside exporter.outdir(), set hook_unsafe to true in the config to allow it", name, filePath)
+}
+
+// resolvePath 返回解析了所有符号链接的绝对路径, 文件不存在时解析存在的最近一级父目录,
+// 指向不存在的文件的符号链接返回错误
+func resolvePath(filePath string) (string, error) {
+	abs, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if _, lstatErr := os.Lstat(abs); lstatErr == nil || !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(abs), rest)
+		abs = parent
+	}
+}
+
+// SetSandbox 设置hook可以写的目录, unsafe为true时不做限制
+func (l *LuaHookManager) SetSandbox(writeDirs []string, unsafe bool) error {
+	dirs := make([]string, 0, len(writeDirs))
+	for _, dir := range writeDirs {
+		resolved, err := resolvePath(dir)
+		if err != nil {
+			return fmt.Errorf("hook sandbox got bad dir %s: %s", dir, err.Error())
+		}
+		dirs = append(dirs, resolved)
+	}
+	l.writeDirs = dirs
+	l.unsafe = unsafe
+	return nil
+}
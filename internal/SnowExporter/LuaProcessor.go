@@ -0,0 +1,254 @@
+package snowExporter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ProcessorRegister GlobalProcess.lua中用这个全局函数注册处理
+const ProcessorRegister = "RegisterProcessor"
+
+// processor GlobalProcess.lua注册的一个处理, fn的参数依次是inputs中的数据, 返回值依次是outputs中的数据
+type processor struct {
+	name    string
+	inputs  []string
+	outputs []string
+	fn      *lua.LFunction
+}
+
+func (p *processor) String() string {
+	return p.name
+}
+
+// registerProcessor RegisterProcessor{name = "...", inputs = {...}, outputs = {...}, fn = function(...) end}, name可以不填
+func (m *LuaHookManager) registerProcessor(L *lua.LState) int {
+	spec := L.CheckTable(1)
+	fn, ok := L.GetField(spec, "fn").(*lua.LFunction)
+	if !ok {
+		L.ArgError(1, "fn must be a function")
+	}
+	p := &processor{
+		name:    fmt.Sprintf("#%d", len(m.processors)+1),
+		inputs:  dataNames(L, spec, "inputs"),
+		outputs: dataNames(L, spec, "outputs"),
+		fn:      fn,
+	}
+	if name, ok := L.GetField(spec, "name").(lua.LString); ok {
+		p.name = string(name)
+	}
+	m.processors = append(m.processors, p)
+	return 0
+}
+
+// dataNames 读取spec中field字段的数据名列表, 没有配置时为空
+func dataNames(L *lua.LState, spec *lua.LTable, field string) []string {
+	value := L.GetField(spec, field)
+	if value == lua.LNil {
+		return nil
+	}
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		L.ArgError(1, field+" must be a list of data names")
+	}
+	names := make([]string, 0, table.Len())
+	for i := 1; i <= table.Len(); i++ {
+		name, ok := table.RawGetInt(i).(lua.LString)
+		if !ok {
+			L.ArgError(1, fmt.Sprintf("%s[%d] must be a data name", field, i))
+		}
+		names = append(names, string(name))
+	}
+	return names
+}
+
+// sortProcessors 输出一个数据的处理排在所有读取它的处理之前, 没有依赖关系时保持注册顺序
+// 同一个数据只能由一个处理输出, 处理之间循环依赖时报错
+func sortProcessors(processors []*processor) ([]*processor, error) {
+	producer := make(map[string]*processor)
+	for _, p := range processors {
+		for _, name := range p.outputs {
+			if other, ok := producer[name]; ok && other != p {
+				return nil, fmt.Errorf("%s is output by both processor %s and %s", name, other, p)
+			}
+			producer[name] = p
+		}
+	}
+	waiting := make(map[*processor]int)
+	next := make(map[*processor][]*processor)
+	for _, p := range processors {
+		seen := make(map[*processor]bool)
+		for _, name := range p.inputs {
+			from, ok := producer[name]
+			if !ok || from == p || seen[from] {
+				continue
+			}
+			seen[from] = true
+			next[from] = append(next[from], p)
+			waiting[p]++
+		}
+	}
+
+	sorted := make([]*processor, 0, len(processors))
+	done := make(map[*processor]bool)
+	for len(sorted) < len(processors) {
+		var ready *processor
+		for _, p := range processors {
+			if !done[p] && waiting[p] == 0 {
+				ready = p
+				break
+			}
+		}
+		if ready == nil {
+			cycle := make([]string, 0)
+			for _, p := range processors {
+				if !done[p] {
+					cycle = append(cycle, p.String())
+				}
+			}
+			return nil, fmt.Errorf("processors %s depend on each other in a cycle", strings.Join(cycle, ", "))
+		}
+		done[ready] = true
+		sorted = append(sorted, ready)
+		for _, p := range next[ready] {
+			waiting[p]--
+		}
+	}
+	return sorted, nil
+}
+
+// processorData 所有处理的输入和输出, 都需要缓存
+func (m *LuaHookManager) processorData() []string {
+	names := make([]string, 0, len(m.processors))
+	for _, p := range m.processors {
+		names = append(names, p.inputs...)
+		names = append(names, p.outputs...)
+	}
+	return names
+}
+
+// RequiredData 导出names时需要一起导出的数据, 一个处理的输入或输出要导出时, 它的所有输入和输出都要导出
+// all是data_def中所有的数据, 处理的输入或输出不在其中时报错
+func (m *LuaHookManager) RequiredData(names []string, all []string) ([]string, error) {
+	known := make(map[string]bool, len(all))
+	for _, name := range all {
+		known[name] = true
+	}
+	problems := make([]string, 0)
+	for _, p := range m.processors {
+		for _, name := range p.inputs {
+			if !known[name] {
+				problems = append(problems, fmt.Sprintf("processor %s input %s is not in data_def", p, name))
+			}
+		}
+		for _, name := range p.outputs {
+			if !known[name] {
+				problems = append(problems, fmt.Sprintf("processor %s output %s is not in data_def, no exporter can write it", p, name))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+
+	required := make(map[string]bool, len(names))
+	for _, name := range names {
+		required[name] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, p := range m.processors {
+			data := append(append([]string{}, p.inputs...), p.outputs...)
+			touched := false
+			for _, name := range data {
+				touched = touched || required[name]
+			}
+			if !touched {
+				continue
+			}
+			for _, name := range data {
+				if !required[name] {
+					required[name] = true
+					changed = true
+				}
+			}
+		}
+	}
+	result := make([]string, 0, len(required))
+	for _, name := range all {
+		if required[name] {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// runProcessors 按顺序执行这次导出涉及的处理, 后面的处理读取的是前面的处理输出的数据, 返回所有输出的数据
+func (m *LuaHookManager) runProcessors() (map[string]*lua.LTable, error) {
+	m.cacheLock.Lock()
+	received := m.received
+	m.received = make(map[string]bool)
+	m.cacheLock.Unlock()
+
+	current := make(map[string]*lua.LTable)
+	changed := make(map[string]*lua.LTable)
+	for _, p := range m.processors {
+		// 输入输出都没有导出时跳过, 只导出了一部分时报错
+		missing := ""
+		skip := true
+		for _, name := range append(append([]string{}, p.inputs...), p.outputs...) {
+			if received[name] {
+				skip = false
+			} else if missing == "" {
+				missing = name
+			}
+		}
+		if skip {
+			continue
+		}
+		if missing != "" {
+			return nil, fmt.Errorf("%s processor %s needs %s, but it is not exported in this run", GlobalProcessLua, p, missing)
+		}
+		args := make([]lua.LValue, 0, len(p.inputs))
+		for _, name := range p.inputs {
+			table, ok := current[name]
+			if !ok {
+				m.cacheLock.RLock()
+				table = m.MapToTable(m.cachedData[name].data)
+				m.cacheLock.RUnlock()
+				current[name] = table
+			}
+			args = append(args, table)
+		}
+		err := m.globalState.CallByParam(lua.P{
+			Fn:      p.fn,
+			NRet:    len(p.outputs),
+			Protect: true,
+		}, args...)
+		if err != nil {
+			if hookErr := hookErrorOf(err); hookErr != nil {
+				return nil, hookErr
+			}
+			return nil, fmt.Errorf("%s processor %s got error: %s", GlobalProcessLua, p, luaErrorMessage(err))
+		}
+		results := make([]lua.LValue, len(p.outputs))
+		for i := range results {
+			results[i] = m.globalState.Get(i - len(results))
+		}
+		m.globalState.Pop(len(results))
+		for i, name := range p.outputs {
+			switch result := results[i].(type) {
+			case *lua.LTable:
+				current[name] = result
+				changed[name] = result
+			case *lua.LNilType:
+				// 返回nil时这个数据不变
+			default:
+				return nil, fmt.Errorf("%s processor %s return %s for %s, need a table or nil", GlobalProcessLua, p, result.Type(), name)
+			}
+		}
+	}
+	return changed, nil
+}
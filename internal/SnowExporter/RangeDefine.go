@@ -0,0 +1,243 @@
+package snowExporter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	RangeSep      = ";"
+	RangeRegex    = "regex:"
+	RangeLen      = "len"
+	RangeNotEmpty = "notempty"
+	RangeUnique   = "unique"
+)
+
+// rangeRule 检查一个单元格, 返回不满足约束的原因, 满足时返回空字符串
+type rangeRule func(text string, value interface{}) string
+
+// ColumnRange 范围行中一列的所有约束, 如 1~100;unique
+type ColumnRange struct {
+	Meta     string
+	rules    []rangeRule
+	notEmpty bool
+	unique   bool
+	seen     map[string]CellPos
+}
+
+// ParseRange 解析范围行的一个单元格, 空单元格返回nil
+// 多个约束以英文";"分割, regex:之后的内容全部作为正则表达式;
+// 范围行以前没有作用, 旧表中的备注等不认识的内容不是错误, 跳过并在unknown中返回, 只有regex:的正则错误时报错
+func ParseRange(text string) (r *ColumnRange, unknown []string, err error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil, nil
+	}
+	r = &ColumnRange{Meta: text}
+	constraints := 0
+	for text != "" {
+		if strings.HasPrefix(text, RangeRegex) {
+			rule, err := newRegexRule(text[len(RangeRegex):])
+			if err != nil {
+				return nil, nil, err
+			}
+			r.rules = append(r.rules, rule)
+			constraints++
+			break
+		}
+		token := text
+		text = ""
+		if index := strings.Index(token, RangeSep); index >= 0 {
+			token, text = token[:index], strings.TrimSpace(token[index+1:])
+		}
+		token = strings.Join(strings.Fields(token), "")
+		if token == "" {
+			continue
+		}
+		var rule rangeRule
+		var err error
+		switch {
+		case token == RangeNotEmpty:
+			r.notEmpty = true
+		case token == RangeUnique:
+			r.unique = true
+			r.seen = make(map[string]CellPos)
+		case strings.HasPrefix(token, RangeLen):
+			rule, err = newLenRule(token[len(RangeLen):])
+		default:
+			rule, err = newNumberRule(token)
+		}
+		if err != nil {
+			unknown = append(unknown, token)
+			continue
+		}
+		if rule != nil {
+			r.rules = append(r.rules, rule)
+		}
+		constraints++
+	}
+	if constraints == 0 {
+		return nil, unknown, nil
+	}
+	return r, unknown, nil
+}
+
+// Check 检查pos位置的单元格, 返回所有不满足约束的原因
+// 空单元格只检查notempty, 其他约束只对填写了的单元格生效
+func (r *ColumnRange) Check(pos CellPos, text string, value interface{}) []string {
+	reasons := make([]string, 0)
+	if strings.TrimSpace(text) == "" {
+		if r.notEmpty {
+			reasons = append(reasons, "empty cell, range needs "+RangeNotEmpty)
+		}
+		return reasons
+	}
+	for _, rule := range r.rules {
+		if reason := rule(text, value); reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+	if r.unique {
+		key := fmt.Sprintf("%T:%v", value, value)
+		if first, exist := r.seen[key]; exist {
+			reasons = append(reasons, fmt.Sprintf("duplicate value %v, already used at %s", value, first))
+		} else {
+			r.seen[key] = pos
+		}
+	}
+	return reasons
+}
+
+func newRegexRule(expr string) (rangeRule, error) {
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compile %s%s: %s", RangeRegex, expr, err)
+	}
+	return func(text string, value interface{}) string {
+		return eachScalar(value, func(v interface{}) string {
+			s := fmt.Sprint(v)
+			if !reg.MatchString(s) {
+				return fmt.Sprintf("%s not match %s%s", s, RangeRegex, expr)
+			}
+			return ""
+		})
+	}, nil
+}
+
+func newLenRule(token string) (rangeRule, error) {
+	compare, err := newCompare(token)
+	if err != nil {
+		return nil, err
+	}
+	return func(text string, value interface{}) string {
+		length := 0
+		switch v := value.(type) {
+		case string:
+			length = utf8.RuneCountInString(v)
+		case []interface{}:
+			length = len(v)
+		case map[string]interface{}:
+			length = len(v)
+		default:
+			return fmt.Sprintf("%T has no length, cannot check %s%s", value, RangeLen, token)
+		}
+		if !compare(float64(length)) {
+			return fmt.Sprintf("length %d out of range %s%s", length, RangeLen, token)
+		}
+		return ""
+	}, nil
+}
+
+func newNumberRule(token string) (rangeRule, error) {
+	compare, err := newCompare(token)
+	if err != nil {
+		return nil, err
+	}
+	return func(text string, value interface{}) string {
+		return eachScalar(value, func(v interface{}) string {
+			var num float64
+			switch n := v.(type) {
+			case int:
+				num = float64(n)
+			case float64:
+				num = n
+			default:
+				return fmt.Sprintf("%v is not a number, cannot check %s", v, token)
+			}
+			if !compare(num) {
+				return fmt.Sprintf("%v out of range %s", v, token)
+			}
+			return ""
+		})
+	}, nil
+}
+
+// newCompare 解析 a~b, >a, >=a, <a, <=a, =a 形式的比较
+func newCompare(token string) (func(float64) bool, error) {
+	if index := strings.Index(token, "~"); index >= 0 {
+		min, err := strconv.ParseFloat(token[:index], 64)
+		if err != nil {
+			return nil, err
+		}
+		max, err := strconv.ParseFloat(token[index+1:], 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(n float64) bool { return n >= min && n <= max }, nil
+	}
+	for _, op := range []string{">=", "<=", "==", ">", "<", "="} {
+		if !strings.HasPrefix(token, op) {
+			continue
+		}
+		limit, err := strconv.ParseFloat(token[len(op):], 64)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case ">=":
+			return func(n float64) bool { return n >= limit }, nil
+		case "<=":
+			return func(n float64) bool { return n <= limit }, nil
+		case ">":
+			return func(n float64) bool { return n > limit }, nil
+		case "<":
+			return func(n float64) bool { return n < limit }, nil
+		default:
+			return func(n float64) bool { return n == limit }, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown range")
+}
+
+// eachScalar 对List和Dict中的每个基本值执行f, 返回第一个失败原因
+func eachScalar(value interface{}, f func(v interface{}) string) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		for _, elem := range v {
+			if reason := eachScalar(elem, f); reason != "" {
+				return reason
+			}
+		}
+		return ""
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if reason := eachScalar(v[k], f); reason != "" {
+				return reason
+			}
+		}
+		return ""
+	default:
+		return f(v)
+	}
+}
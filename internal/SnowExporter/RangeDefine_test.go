@@ -0,0 +1,68 @@
+package snowExporter
+
+import (
+	conf "exporterX/DataExporter"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestParseRangeSkipsLegacyText(t *testing.T) {
+	cases := []struct {
+		text    string
+		unknown []string
+		rules   int
+	}{
+		{"等级上限", []string{"等级上限"}, 0},
+		{"策划备注: 填1到100", []string{"策划备注:填1到100"}, 0},
+		{"lengthofname", []string{"lengthofname"}, 0},
+		{"1~100;备注", []string{"备注"}, 1},
+		{"旧备注;notempty", []string{"旧备注"}, 0},
+		{"1~100;unique", nil, 1},
+	}
+	for _, c := range cases {
+		r, unknown, err := ParseRange(c.text)
+		if err != nil {
+			t.Errorf("ParseRange(%q) got error: %s", c.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(unknown, c.unknown) {
+			t.Errorf("ParseRange(%q) unknown = %q, want %q", c.text, unknown, c.unknown)
+		}
+		rules := 0
+		if r != nil {
+			rules = len(r.rules)
+		}
+		if rules != c.rules {
+			t.Errorf("ParseRange(%q) got %d rules, want %d", c.text, rules, c.rules)
+		}
+	}
+	if r, _, _ := ParseRange("只是备注"); r != nil {
+		t.Errorf("range with only legacy text is not nil")
+	}
+	if _, _, err := ParseRange("regex:[a-"); err == nil {
+		t.Errorf("bad regex got no error")
+	}
+}
+
+func TestReadRangeWithLegacyText(t *testing.T) {
+	intType, _ := ParseType("Int")
+	strType, _ := ParseType("Str")
+	s := &SnowSingleExporter{
+		logger:   log.New(ioutil.Discard, "", 0),
+		dataDef:  &conf.DataDefine{Name: "LegacyData", Excel: "legacy.xlsx", Sheet: "s"},
+		headType: []*HeadType{intType, strType, intType},
+	}
+	s.ReadRange(2, []string{"主键", "名字, 不超过8个字", "1~100;旧备注"})
+	if len(s.errors) > 0 {
+		t.Fatalf("legacy range row got errors: %s", s.errors.Report())
+	}
+	if s.ranges[0] != nil || s.ranges[1] != nil {
+		t.Errorf("legacy text becomes a constraint")
+	}
+	pos := CellPos{4, 3}
+	if reasons := s.ranges[2].Check(pos, "101", 101); len(reasons) != 1 {
+		t.Errorf("known constraint next to legacy text is not checked, got %v", reasons)
+	}
+}
@@ -0,0 +1,151 @@
+package snowExporter
+
+import (
+	conf "exporterX/DataExporter"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// refKeys 被Ref引用的数据的key类型, 第一次用到时读取那个数据的类型行, 被引用的数据不需要先导出
+type refKeys struct {
+	lock     sync.Mutex
+	srcDir   string
+	dataDefs map[string]conf.DataDefine
+	types    map[string]*refKey
+}
+
+// refKey 一个数据的key类型, 每个数据只读取一次, 不同数据的读取可以并行
+type refKey struct {
+	once    sync.Once
+	keyType string
+	err     error
+}
+
+func newRefKeys(srcDir string, dataDefs []conf.DataDefine) *refKeys {
+	r := &refKeys{
+		srcDir:   srcDir,
+		dataDefs: make(map[string]conf.DataDefine, len(dataDefs)),
+		types:    make(map[string]*refKey),
+	}
+	for _, dataDef := range dataDefs {
+		r.dataDefs[dataDef.Name] = dataDef
+	}
+	return r
+}
+
+// KeyType 返回数据name的key类型, Int或Str, isMap数据的key总是Str
+func (r *refKeys) KeyType(name string) (string, error) {
+	r.lock.Lock()
+	entry, ok := r.types[name]
+	if !ok {
+		entry = &refKey{}
+		r.types[name] = entry
+	}
+	r.lock.Unlock()
+	entry.once.Do(func() {
+		entry.keyType, entry.err = r.readType(name)
+	})
+	if entry.err != nil {
+		// 读取失败时不记录, 下次用到时重新读取
+		r.lock.Lock()
+		if r.types[name] == entry {
+			delete(r.types, name)
+		}
+		r.lock.Unlock()
+	}
+	return entry.keyType, entry.err
+}
+
+// readType 读取数据name的key类型, key列也是Ref时沿着引用找到最终的key类型
+func (r *refKeys) readType(name string) (string, error) {
+	seen := make(map[string]bool)
+	target := name
+	for !seen[target] {
+		seen[target] = true
+		dataDef, ok := r.dataDefs[target]
+		if !ok {
+			return "", fmt.Errorf("Ref(%s) got unknown data %s, it is not in data_def", name, target)
+		}
+		if dataDef.IsMapData {
+			return Str, nil
+		}
+		first, err := readKeyType(path.Join(r.srcDir, dataDef.Excel), dataDef.Sheet)
+		if err != nil {
+			return "", fmt.Errorf("Ref(%s) read key type from %s %s got error: %s", name, dataDef.Excel, dataDef.Sheet, err)
+		}
+		if first.MetaType != RefPrefix {
+			return KeyType(first), nil
+		}
+		target = first.RefTo
+	}
+	return "", fmt.Errorf("Ref(%s) key columns reference each other in a cycle at %s", name, target)
+}
+
+// Forget 数据重新导出时key类型可能变了, 下次用到时重新读取
+func (r *refKeys) Forget(name string) {
+	r.lock.Lock()
+	delete(r.types, name)
+	r.lock.Unlock()
+}
+
+// readKeyType 读取sheet中跳过skiprow之后第一行的第一列类型
+func readKeyType(filePath string, sheet string) (first *HeadType, err error) {
+	f, err := excelize.OpenFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := f.Rows(sheet)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		columns, err := rows.Columns()
+		if err != nil {
+			return nil, err
+		}
+		if len(columns) > 0 && columns[0] == SkipRow {
+			continue
+		}
+		text := ""
+		if len(columns) > 0 {
+			text = columns[0]
+		}
+		if reason := catchReason(func() { first, _ = ParseType(text) }); reason != "" {
+			return nil, fmt.Errorf("bad key type %s: %s", text, reason)
+		}
+		return first, nil
+	}
+	return nil, fmt.Errorf("no type row")
+}
+
+// resolveRefs 设置类型中所有Ref引用的数据的key类型, 失败时返回原因
+func (s *SnowSingleExporter) resolveRefs(headType *HeadType) string {
+	switch headType.MetaType {
+	case RefPrefix:
+		keyType, err := s.refKeys.KeyType(headType.RefTo)
+		if err != nil {
+			return err.Error()
+		}
+		headType.RefKey = keyType
+	case ListPrefix:
+		return s.resolveRefs(headType.ListIn)
+	case DictPrefix:
+		keys := make([]string, 0, len(headType.DictIn))
+		for key := range headType.DictIn {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if reason := s.resolveRefs(headType.DictIn[key]); reason != "" {
+				return reason
+			}
+		}
+	}
+	return ""
+}
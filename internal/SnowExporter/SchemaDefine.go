@@ -0,0 +1,161 @@
+package snowExporter
+
+import (
+	schema "exporterX/internal/Schema"
+	"sort"
+)
+
+// schemaType 把HeadType转换成和导出工具无关的类型, Ref的类型是被引用的数据的key类型
+func schemaType(headType *HeadType) *schema.Type {
+	switch headType.MetaType {
+	case Int:
+		return &schema.Type{Kind: schema.Int}
+	case Float:
+		return &schema.Type{Kind: schema.Float}
+	case Str:
+		return &schema.Type{Kind: schema.Str}
+	case Bool:
+		return &schema.Type{Kind: schema.Bool}
+	case ListPrefix:
+		return &schema.Type{Kind: schema.List, Elem: schemaType(headType.ListIn)}
+	case DictPrefix:
+		keys := make([]string, 0, len(headType.DictIn))
+		for key := range headType.DictIn {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		t := &schema.Type{Kind: schema.Dict, Fields: make([]*schema.Field, 0, len(keys))}
+		for _, key := range keys {
+			t.Fields = append(t.Fields, &schema.Field{Name: key, Type: schemaType(headType.DictIn[key])})
+		}
+		return t
+	case EnumPrefix:
+		t := &schema.Type{Kind: schema.Enum, Enum: make([]schema.EnumValue, 0, len(headType.EnumIn))}
+		for name, value := range headType.EnumIn {
+			t.Enum = append(t.Enum, schema.EnumValue{Name: name, Value: value})
+		}
+		sort.Slice(t.Enum, func(i, j int) bool {
+			if t.Enum[i].Value != t.Enum[j].Value {
+				return t.Enum[i].Value < t.Enum[j].Value
+			}
+			return t.Enum[i].Name < t.Enum[j].Name
+		})
+		return t
+	case FuncPrefix:
+		return &schema.Type{Kind: schema.Func}
+	case RefPrefix:
+		if headType.RefKey == Int {
+			return &schema.Type{Kind: schema.Int, RefTo: headType.RefTo}
+		}
+		return &schema.Type{Kind: schema.Str, RefTo: headType.RefTo}
+	}
+	return &schema.Type{Kind: schema.Any}
+}
+
+// headerSchema 一列的类型, 被lua劫持的字段类型未知
+func headerSchema(header *Header) *schema.Type {
+	if header == nil || header.hooker != nil {
+		return &schema.Type{Kind: schema.Any}
+	}
+	return schemaType(header.headType)
+}
+
+// schema 按keysOrder的字段顺序生成数据结构, isMap数据按key排序, 没有类型行定义的字段是Any
+func (s *SnowSingleExporter) schema(data map[string]interface{}, keysOrder []string, isMap bool) *schema.Table {
+	table := &schema.Table{
+		Name:    s.dataDef.Name,
+		SubPath: s.dataDef.SubPath,
+		IsMap:   isMap,
+		RowFile: s.dataDef.RowFile,
+	}
+	if isMap {
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			table.Fields = append(table.Fields, &schema.Field{
+				Name: key,
+				Type: headerSchema(s.mapHeaders[key]),
+			})
+		}
+		return table
+	}
+
+	headers := make(map[string]*Header, len(s.header))
+	for _, header := range s.header {
+		if header.Needed() {
+			headers[header.Key()] = header
+		}
+	}
+	table.Key = &schema.Type{Kind: schema.Str}
+	if len(s.header) > 0 && KeyType(s.header[0].headType) == Int {
+		table.Key = &schema.Type{Kind: schema.Int}
+	}
+	for _, key := range keysOrder {
+		table.Fields = append(table.Fields, &schema.Field{Name: key, Type: headerSchema(headers[key])})
+	}
+	return table
+}
+
+// matchSchema value能否按t导出, nil是没有这个字段; 行hook返回的值必须符合列的类型, 否则生成的代码读不了导出的数据
+// lua的整数可以是Float, lua中空的Dict会变成空的List
+func matchSchema(t *schema.Type, value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	switch t.Kind {
+	case schema.Int, schema.Enum:
+		_, ok := value.(int)
+		return ok
+	case schema.Float:
+		switch value.(type) {
+		case int, float64:
+			return true
+		}
+		return false
+	case schema.Str:
+		_, ok := value.(string)
+		return ok
+	case schema.Bool:
+		_, ok := value.(bool)
+		return ok
+	case schema.List:
+		list, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, elem := range list {
+			if !matchSchema(t.Elem, elem) {
+				return false
+			}
+		}
+		return true
+	case schema.Dict:
+		if list, ok := value.([]interface{}); ok {
+			return len(list) == 0
+		}
+		dict, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		fields := make(map[string]*schema.Type, len(t.Fields))
+		for _, field := range t.Fields {
+			fields[field.Name] = field.Type
+		}
+		for key, v := range dict {
+			if fieldType, ok := fields[key]; !ok || !matchSchema(fieldType, v) {
+				return false
+			}
+		}
+		return true
+	case schema.Func:
+		switch value.(type) {
+		case int, float64, []interface{}:
+			return true
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package snowExporter
+
+import (
+	schema "exporterX/internal/Schema"
+	"testing"
+)
+
+func TestMatchSchema(t *testing.T) {
+	ints := &schema.Type{Kind: schema.List, Elem: &schema.Type{Kind: schema.Int}}
+	dict := &schema.Type{Kind: schema.Dict, Fields: []*schema.Field{
+		{Name: "a", Type: &schema.Type{Kind: schema.Str}},
+		{Name: "b", Type: ints},
+	}}
+	cases := []struct {
+		t     *schema.Type
+		value interface{}
+		want  bool
+	}{
+		{&schema.Type{Kind: schema.Int}, 1, true},
+		{&schema.Type{Kind: schema.Int}, 1.5, false},
+		{&schema.Type{Kind: schema.Int}, "1", false},
+		{&schema.Type{Kind: schema.Int}, nil, true},
+		{&schema.Type{Kind: schema.Float}, 2, true},
+		{&schema.Type{Kind: schema.Float}, 2.5, true},
+		{&schema.Type{Kind: schema.Str}, map[string]interface{}{}, false},
+		{&schema.Type{Kind: schema.Bool}, 0, false},
+		{ints, []interface{}{1, 2}, true},
+		{ints, []interface{}{1, "x"}, false},
+		{ints, map[string]interface{}{"1": 1}, false},
+		{dict, map[string]interface{}{"a": "x", "b": []interface{}{1}}, true},
+		{dict, map[string]interface{}{"a": 1}, false},
+		{dict, map[string]interface{}{"c": "x"}, false},
+		{dict, []interface{}{}, true},
+		{dict, []interface{}{"x"}, false},
+		{&schema.Type{Kind: schema.Func}, []interface{}{2, []interface{}{}}, true},
+		{&schema.Type{Kind: schema.Func}, "x*x", false},
+		{&schema.Type{Kind: schema.Any}, "x", true},
+	}
+	for _, c := range cases {
+		if got := matchSchema(c.t, c.value); got != c.want {
+			t.Errorf("matchSchema(%s, %#v) = %v, want %v", c.t.Kind, c.value, got, c.want)
+		}
+	}
+}
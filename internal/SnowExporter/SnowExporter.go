@@ -1,13 +1,18 @@
 package snowExporter
 
 import (
+	"errors"
 	conf "exporterX/DataExporter"
 	factory "exporterX/DataExporter/Factory"
-	tojson "exporterX/internal/ToJson"
 	tolua "exporterX/internal/ToLua"
+	"fmt"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,7 +33,6 @@ func init() {
 		logger: log.New(os.Stdout, "[SnowExporter]: ", log.Lshortfile),
 	})
 	LuaHooker = NewLuaHookManager()
-	LuaHooker.PrepareHookFunction()
 }
 
 type SnowExporter struct {
@@ -36,48 +40,61 @@ type SnowExporter struct {
 	cacheMap            map[string]bool
 	lock                sync.Mutex
 	cacheSingleExporter map[string]*SnowSingleExporter
+	knownKeys           map[string]map[string]bool
+	nameRule            *regexp.Regexp
+	refKeys             *refKeys
 }
 
 func (s *SnowExporter) Init() {
+	s.initCacheMap()
+	s.cacheSingleExporter = make(map[string]*SnowSingleExporter)
+	s.knownKeys = make(map[string]map[string]bool)
+}
+
+func (s *SnowExporter) initCacheMap() {
 	cacheNameList := LuaHooker.InitGlobalProcess()
-	if cacheNameList != nil && len(cacheNameList) > 0 {
-		s.cacheMap = make(map[string]bool)
-		for _, cacheName := range cacheNameList {
-			s.cacheMap[cacheName] = true
-		}
+	s.cacheMap = make(map[string]bool)
+	for _, cacheName := range cacheNameList {
+		s.cacheMap[cacheName] = true
 	}
-	s.cacheSingleExporter = make(map[string]*SnowSingleExporter)
+}
+
+// HasTool tool注册了Writer时可以使用, 在读取配置时检查一次
+func (s *SnowExporter) HasTool(tool string) bool {
+	return factory.GetWriter(tool) != nil
 }
 
 func (s *SnowExporter) Version() string {
 	return "internal/SnowExporter/SnowExporter"
 }
 
-func (s *SnowExporter) DoExport(n int, tool string, filePath string, outDir string, dataDef *conf.DataDefine) (string, error) {
-	if tool != conf.Tool_To_Lua && tool != conf.Tool_To_Json {
-		panic("Cannot use tool: " + tool)
-	}
+func (s *SnowExporter) DoExport(n int, outputs []conf.OutputConf, filePath string, dataDef *conf.DataDefine) (string, error) {
 	sse := &SnowSingleExporter{
 		logger:       log.New(os.Stdout, "["+dataDef.Excel+" "+dataDef.Sheet+"]", log.Lshortfile),
 		n:            n,
-		tool:         tool,
+		outputs:      outputs,
+		nameRule:     s.nameRule,
+		refKeys:      s.refKeys,
 		filePath:     filePath,
-		outDir:       path.Join(outDir, dataDef.SubPath),
 		dataDef:      dataDef,
 		headType:     make([]*HeadType, 0, 4),
 		defaultValue: make([]interface{}, 0, 4),
 		header:       make([]*Header, 0, 4),
 		data:         make([][]interface{}, 0, 4),
 		mapdata:      make(map[string]interface{}),
+		tagged:       make(map[string]string),
+		mapHeaders:   make(map[string]*Header),
+		keyLines:     make(map[string]int),
 	}
 
 	if _, exist := s.cacheMap[dataDef.Name]; exist {
 		sse.cache = true
 	}
+	s.refKeys.Forget(dataDef.Name)
 	s.lock.Lock()
 	s.cacheSingleExporter[dataDef.Name] = sse
 	s.lock.Unlock()
-	return sse.DoExport(filePath, outDir)
+	return sse.DoExport(filePath)
 }
 
 func (s *SnowExporter) SetCpuNum(n int) {
@@ -85,10 +102,162 @@ func (s *SnowExporter) SetCpuNum(n int) {
 	for i := 0; i < n; i++ {
 		LuaStates[i] = lua.NewState()
 	}
+	// hook和Func的解析使用同一个虚拟机, 每个worker的hook可以并行执行
+	LuaHooker.SetHookStates(LuaStates)
+}
+
+func (s *SnowExporter) SetHookDir(dir string) error {
+	return catchError(func() { LuaHooker.SetHookDir(dir) })
+}
+
+func (s *SnowExporter) SetHookSandbox(writeDirs []string, unsafe bool) error {
+	return LuaHooker.SetSandbox(writeDirs, unsafe)
+}
+
+// SetDataDefine 记录所有数据, Ref从被引用的数据的类型行读取key类型
+func (s *SnowExporter) SetDataDefine(srcDir string, dataDefs []conf.DataDefine) {
+	s.refKeys = newRefKeys(srcDir, dataDefs)
+}
+
+func (s *SnowExporter) SetNameRule(rule string) error {
+	nameRule, err := regexp.Compile(rule)
+	if err != nil {
+		return err
+	}
+	s.nameRule = nameRule
+	return nil
+}
+
+// Inputs 有hook文件的数据依赖hook文件和hook子目录中的所有lua模块,
+// 需要缓存给GlobalProcess.lua的数据每次都要导出
+func (s *SnowExporter) Inputs(dataDef *conf.DataDefine) ([]string, bool) {
+	files := make([]string, 0, 4)
+	_, always := s.cacheMap[dataDef.Name]
+	if always {
+		files = append(files, LuaHooker.GlobalProcessPath())
+	}
+	if _, ok := LuaHooker.hookMap.Load(dataDef.Name); ok {
+		files = append(files, LuaHooker.HookFiles()...)
+	}
+	return files, always
+}
+
+// Requires GlobalProcess.lua中处理的输入输出必须在data_def中, 导出一个处理的输入或输出时一起导出它的所有输入输出
+func (s *SnowExporter) Requires(names []string, all []string) ([]string, error) {
+	return LuaHooker.RequiredData(names, all)
+}
+
+func (s *SnowExporter) WatchDirs() []string {
+	return []string{LuaHooker.hookDir}
+}
+
+// Reload 重新加载变化了的hook, GlobalProcess.lua变化时重新初始化缓存列表
+func (s *SnowExporter) Reload(files []string) error {
+	if err := LuaHooker.ReloadHookFunction(); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if path.Base(filepath.ToSlash(file)) == GlobalProcessLua {
+			return catchError(func() { s.initCacheMap() })
+		}
+	}
+	return nil
+}
+
+func (s *SnowExporter) Keys(name string) []string {
+	exporter, ok := s.cacheSingleExporter[name]
+	if !ok {
+		return nil
+	}
+	if exporter.dataDef.IsMapData {
+		keys := make([]string, 0, len(exporter.mapdata))
+		for key := range exporter.mapdata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	return exporter.rowsOrder
+}
+
+func (s *SnowExporter) RefTargets(name string) []string {
+	exporter, ok := s.cacheSingleExporter[name]
+	if !ok {
+		return nil
+	}
+	seen := make(map[string]bool)
+	targets := make([]string, 0)
+	for _, ref := range exporter.refs {
+		if !seen[ref.target] {
+			seen[ref.target] = true
+			targets = append(targets, ref.target)
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+func (s *SnowExporter) SetKeys(name string, keys []string) {
+	known := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		known[key] = true
+	}
+	s.knownKeys[name] = known
+}
+
+// CheckReferences 检查所有Ref字段引用的key在目标表中存在, 目标表不在本次导出中时跳过
+func (s *SnowExporter) CheckReferences() error {
+	errs := make(conf.ExportErrors, 0)
+	skipped := make(map[string]bool)
+	names := make([]string, 0, len(s.cacheSingleExporter))
+	for name := range s.cacheSingleExporter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		exporter := s.cacheSingleExporter[name]
+		for _, ref := range exporter.refs {
+			var exist bool
+			targetName := ref.target
+			if target, ok := s.cacheSingleExporter[ref.target]; ok && len(target.errors) == 0 {
+				_, exist = target.mapdata[ref.value]
+				targetName = fmt.Sprintf("%s (%s!%s)", ref.target, target.dataDef.Excel, target.dataDef.Sheet)
+			} else if known, ok := s.knownKeys[ref.target]; ok {
+				// 没有变化跳过导出的数据, 使用manifest中记录的key
+				exist = known[ref.value]
+			} else {
+				if !skipped[ref.target] {
+					skipped[ref.target] = true
+					s.logger.Printf("%s is not exported in this run, skip checking references to it", ref.target)
+				}
+				continue
+			}
+			if !exist {
+				errs = append(errs, &conf.CellError{
+					Workbook: exporter.dataDef.Excel,
+					Sheet:    exporter.dataDef.Sheet,
+					Cell:     ref.pos.String(),
+					Key:      ref.header.Key(),
+					Type:     ref.header.Type(),
+					Text:     ref.value,
+					Reason:   fmt.Sprintf("dangling reference, %s has no key %s", targetName, ref.value),
+				})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
-func (s *SnowExporter) AfterExport() {
-	LuaHooker.GlobalProcessCacheData()
+func (s *SnowExporter) AfterExport() error {
+	if err := LuaHooker.GlobalProcessCacheData(); err != nil {
+		if hookErr, ok := err.(*HookError); ok {
+			return s.hookError(hookErr)
+		}
+		return err
+	}
 	dataName2MapData := LuaHooker.GlobalProcessGetChangedData()
 	for name, mapData := range dataName2MapData {
 		s.logger.Printf("Rewrite %s", name)
@@ -96,30 +265,137 @@ func (s *SnowExporter) AfterExport() {
 			exporter.WriteDataFromLua(mapData)
 		}
 	}
+	return nil
+}
+
+// hookError 把GlobalProcess.lua中exporter.error指定的数据, key和字段转换成单元格错误
+func (s *SnowExporter) hookError(hookErr *HookError) error {
+	exporter, ok := s.cacheSingleExporter[hookErr.Data]
+	if !ok {
+		return fmt.Errorf("%s: %s", GlobalProcessLua, hookErr.Message)
+	}
+	line, ok := exporter.keyLines[hookErr.Key]
+	if !ok {
+		exporter.addError(CellPos{}, hookErr.Key, "", "", hookErr.Message)
+		return exporter.errors
+	}
+	pos := CellPos{line + 1, 1}
+	if exporter.dataDef.IsMapData {
+		header := exporter.mapHeaders[hookErr.Key]
+		exporter.addError(CellPos{line + 1, 2}, hookErr.Key, header.Type(), "", hookErr.Message)
+		return exporter.errors
+	}
+	key, typ := exporter.header[0].Key(), exporter.header[0].Type()
+	for i, header := range exporter.header {
+		if hookErr.Field != "" && header.Key() == hookErr.Field {
+			pos, key, typ = CellPos{line + 1, i + 1}, header.Key(), header.Type()
+			break
+		}
+	}
+	exporter.addError(pos, key, typ, "", hookErr.Message)
+	return exporter.errors
+}
+
+// WriteIndex 导出目标的tool注册了IndexWriter时生成公共文件
+func (s *SnowExporter) WriteIndex(output conf.OutputConf, dataDefs []conf.DataDefine) ([]string, error) {
+	indexWriter := factory.GetIndexWriter(output.Tool)
+	if indexWriter == nil {
+		return nil, nil
+	}
+	return indexWriter(output, dataDefs)
+}
+
+// reference Ref字段中对其他表key的一次引用
+type reference struct {
+	pos    CellPos
+	header *Header
+	target string
+	value  string
 }
 
 type SnowSingleExporter struct {
 	logger       *log.Logger
 	n            int
-	tool         string
+	outputs      []conf.OutputConf
+	nameRule     *regexp.Regexp
+	refKeys      *refKeys
 	filePath     string
-	outDir       string
 	dataDef      *conf.DataDefine
 	headType     []*HeadType
 	defaultValue []interface{}
 	header       []*Header
+	ranges       []*ColumnRange
 	data         [][]interface{}
+	rowHook      func(row map[string]interface{}) (map[string]interface{}, error)
+	rowMaps      []map[string]interface{}
 	mapdata      map[string]interface{}
 	cache        bool
 	keysOrder    []string
 	rowsOrder    []string
+	dataLines    []int
+	keyLines     map[string]int
+	refs         []reference
+	tagged       map[string]string
+	mapHeaders   map[string]*Header
+	errors       conf.ExportErrors
+}
+
+// addError 记录一个单元格错误, pos未知的部分为0
+func (s *SnowSingleExporter) addError(pos CellPos, key string, typ string, text string, reason string) {
+	s.errors = append(s.errors, &conf.CellError{
+		Workbook: s.dataDef.Excel,
+		Sheet:    s.dataDef.Sheet,
+		Cell:     pos.String(),
+		Key:      key,
+		Type:     typ,
+		Text:     text,
+		Reason:   reason,
+	})
+}
+
+// checkName 字段名必须匹配name_rule, 有to_lua导出目标时不能是lua保留字
+func (s *SnowSingleExporter) checkName(name string) string {
+	if s.nameRule != nil && !s.nameRule.MatchString(name) {
+		return fmt.Sprintf("bad field name %s, need match name_rule %s", name, s.nameRule)
+	}
+	for _, output := range s.outputs {
+		if output.Tool == conf.Tool_To_Lua && tolua.LuaKeywords[name] {
+			return fmt.Sprintf("bad field name %s, it is reserved by lua", name)
+		}
+	}
+	return ""
 }
 
-func (s *SnowSingleExporter) DoExport(filePath string, outDir string) (string, error) {
+// recoverReason 把解析中的panic转换成错误原因
+func recoverReason(r interface{}) string {
+	if e, ok := r.(*parseError); ok {
+		return e.reason
+	}
+	return fmt.Sprint(r)
+}
+
+// parseCell 解析一个单元格, 解析失败时返回错误原因
+func (s *SnowSingleExporter) parseCell(header *Header, text string) (v interface{}, reason string) {
+	defer func() {
+		if r := recover(); r != nil {
+			v, reason = nil, recoverReason(r)
+		}
+	}()
+	return header.ParseData(text), ""
+}
+
+func (s *SnowSingleExporter) DoExport(filePath string) (name string, err error) {
 	s.logger.Printf("DoExport [%s] from %s %s", s.dataDef.Name, s.dataDef.Excel, s.dataDef.Sheet)
+	defer func() {
+		if r := recover(); r != nil {
+			s.addError(CellPos{}, "", "", "", recoverReason(r))
+			name, err = s.dataDef.Name, s.errors
+		}
+	}()
 	f, err := excelize.OpenFile(filePath)
 	if err != nil {
-		s.logger.Printf("excelize open %s got error: %s", filePath, err.Error())
+		s.addError(CellPos{}, "", "", "", fmt.Sprintf("excelize open %s got error: %s", filePath, err.Error()))
+		return s.dataDef.Name, s.errors
 	}
 	defer func() {
 		if err := f.Close(); err != nil {
@@ -129,15 +405,16 @@ func (s *SnowSingleExporter) DoExport(filePath string, outDir string) (string, e
 
 	rows, err := f.GetRows(s.dataDef.Sheet)
 	if err != nil {
-		s.logger.Panicf("Read %s Sheet %s got error %s", s.dataDef.Excel, s.dataDef.Sheet, err)
+		s.addError(CellPos{}, "", "", "", fmt.Sprintf("read sheet got error %s", err))
+		return s.dataDef.Name, s.errors
 	}
 	if len(rows) < 3 {
-		s.logger.Panicf("Read %s Sheet %s got %d rows", s.dataDef.Excel, s.dataDef.Sheet, len(rows))
-		return "", nil
+		s.addError(CellPos{}, "", "", "", fmt.Sprintf("got %d rows, need at least 3", len(rows)))
+		return s.dataDef.Name, s.errors
 	}
 	line := 0
 	for {
-		if rows[line][0] != SkipRow {
+		if len(rows[line]) == 0 || rows[line][0] != SkipRow {
 			break
 		}
 		line++
@@ -148,27 +425,33 @@ func (s *SnowSingleExporter) DoExport(filePath string, outDir string) (string, e
 		line++
 
 		for line < len(rows) {
-			s.ReadMapping(rows[line])
+			s.ReadMapping(line, rows[line])
 			line++
 		}
 
+		if len(s.errors) > 0 {
+			return s.dataDef.Name, s.errors
+		}
 		s.WriteMapData()
 
 	} else {
 
-		s.ReadType(rows[line])
+		s.ReadType(line, rows[line])
 		line++
-		s.ReadRange(rows[line])
+		s.ReadRange(line, rows[line])
 		line++
-		s.ReadHeader(rows[line])
+		s.ReadHeader(line, rows[line])
 		line++
 
 		for line < len(rows) {
-			s.ReadData(rows[line])
+			s.ReadData(line, rows[line])
 			line++
 		}
 
 		s.WriteData()
+		if len(s.errors) > 0 {
+			return s.dataDef.Name, s.errors
+		}
 	}
 
 	if s.cache {
@@ -178,24 +461,57 @@ func (s *SnowSingleExporter) DoExport(filePath string, outDir string) (string, e
 	return s.dataDef.Name, nil
 }
 
-func (s *SnowSingleExporter) ReadMapping(row []string) {
+func (s *SnowSingleExporter) ReadMapping(line int, row []string) {
 	// 每一行数据是 Key  Value  Type的形式
 	if len(row) < 3 {
 		return
 	}
-	key := strings.Replace(row[0], " ", "", -1)
-	text := strings.Replace(row[1], " ", "", -1)
-	keyType, defaultValue := ParseType(row[2])
-	header := NewHeader(s.n, s.dataDef.Name, key, 1, keyType, defaultValue)
-	value := header.ParseData(text)
+	key, tags, err := SplitTags(strings.Replace(row[0], " ", "", -1))
+	if err != nil {
+		s.addError(CellPos{line + 1, 1}, row[0], row[2], row[0], err.Error())
+		return
+	}
+	if reason := s.checkName(key); reason != "" {
+		s.addError(CellPos{line + 1, 1}, key, row[2], row[0], reason)
+		return
+	}
+	if tags != "" {
+		s.tagged[key] = tags
+	}
+	text := row[1]
+	var keyType *HeadType
+	var defaultValue interface{}
+	if reason := catchReason(func() { keyType, defaultValue = ParseType(row[2]) }); reason != "" {
+		s.addError(CellPos{line + 1, 3}, key, row[2], row[2], reason)
+		return
+	}
+	if reason := s.resolveRefs(keyType); reason != "" {
+		s.addError(CellPos{line + 1, 3}, key, row[2], row[2], reason)
+		return
+	}
+	header := NewHeader(s.n, s.dataDef, key, 1, keyType, defaultValue)
+	pos := CellPos{line + 1, 2}
+	value, reason := s.parseCell(header, text)
+	if reason != "" {
+		s.addError(pos, key, header.Type(), text, reason)
+		return
+	}
 	s.mapdata[key] = value
+	s.mapHeaders[key] = header
+	s.keyLines[key] = line
 }
 
-func (s *SnowSingleExporter) ReadType(row []string) {
+func (s *SnowSingleExporter) ReadType(line int, row []string) {
 	var headtype *HeadType
 	var defaultValue interface{}
-	for _, v := range row {
-		headtype, defaultValue = ParseType(v)
+	for i, v := range row {
+		if reason := catchReason(func() { headtype, defaultValue = ParseType(v) }); reason != "" {
+			s.addError(CellPos{line + 1, i + 1}, "", v, v, reason)
+			headtype, defaultValue = NewHeadType(Nil, Nil), nil
+		} else if reason := s.resolveRefs(headtype); reason != "" {
+			s.addError(CellPos{line + 1, i + 1}, "", v, v, reason)
+			headtype, defaultValue = NewHeadType(Nil, Nil), nil
+		}
 		s.headType = append(s.headType, headtype)
 		s.defaultValue = append(s.defaultValue, defaultValue)
 	}
@@ -205,177 +521,417 @@ func (s *SnowSingleExporter) ReadType(row []string) {
 	// log.Println(string(res2), len(s.defaultValue))
 }
 
-func (s *SnowSingleExporter) ReadRange(row []string) {
+// catchError 执行f, 把f中的panic转换成error
+func catchError(f func()) error {
+	if reason := catchReason(f); reason != "" {
+		return errors.New(reason)
+	}
+	return nil
+}
+
+// catchReason 执行f, 返回f中panic的原因
+func catchReason(f func()) (reason string) {
+	defer func() {
+		if r := recover(); r != nil {
+			reason = recoverReason(r)
+		}
+	}()
+	f()
+	return ""
+}
 
+// ReadRange 读取类型行下面的范围行, 每列可以配置取值约束, 不填则不检查
+func (s *SnowSingleExporter) ReadRange(line int, row []string) {
+	s.ranges = make([]*ColumnRange, len(s.headType))
+	for i, v := range row {
+		if i >= len(s.ranges) {
+			break
+		}
+		columnRange, unknown, err := ParseRange(v)
+		if err != nil {
+			s.addError(CellPos{line + 1, i + 1}, "", s.headType[i].Meta, v, err.Error())
+			continue
+		}
+		if len(unknown) > 0 {
+			s.logger.Printf("warning: %s!%s!%s range %q has unknown constraint %s, skip it", s.dataDef.Excel, s.dataDef.Sheet, CellPos{line + 1, i + 1}, v, strings.Join(unknown, ", "))
+		}
+		s.ranges[i] = columnRange
+	}
 }
 
-func (s *SnowSingleExporter) ReadHeader(row []string) {
+// ReadHeader 读取程序字段行, 字段名可以带导出标签
+func (s *SnowSingleExporter) ReadHeader(line int, row []string) {
 	if len(row) > len(s.headType) {
 		s.logger.Panicf("type length %d dont match key %v length %d", len(s.headType), row, len(row))
 	}
 	for i, v := range row {
-		s.header = append(s.header, NewHeader(s.n, s.dataDef.Name, v, i, s.headType[i], s.defaultValue[i]))
+		name, tags, err := SplitTags(strings.NewReplacer(" ", "", "\n", "").Replace(v))
+		if err != nil {
+			s.addError(CellPos{line + 1, i + 1}, v, s.headType[i].Meta, v, err.Error())
+			name = ""
+		} else if name != "" {
+			if reason := s.checkName(name); reason != "" {
+				s.addError(CellPos{line + 1, i + 1}, name, s.headType[i].Meta, v, reason)
+				name = ""
+			}
+		}
+		if i == 0 && tags != "" {
+			// 每个导出目标都用第一列作为行的key, 不能被过滤掉
+			s.addError(CellPos{line + 1, i + 1}, name, s.headType[i].Meta, v, "key column can not have tags, it is exported to every output")
+			tags = ""
+		}
+		header := NewHeader(s.n, s.dataDef, name, i, s.headType[i], s.defaultValue[i])
+		if tags != "" && header.Needed() {
+			s.tagged[header.Key()] = tags
+		}
+		s.header = append(s.header, header)
 	}
+	// 有字段叫Row时[数据名]_Row是这个字段的hook
+	for _, header := range s.header {
+		if header.Key() == RowHookName {
+			return
+		}
+	}
+	s.rowHook = LuaHooker.GetRowHookHandler(s.n, s.dataDef.Name)
+}
+
+// outputIndexes 导出的列, 不包括没有字段名的列和导表标签列
+func (s *SnowSingleExporter) outputIndexes() []int {
+	indexes := make([]int, 0, len(s.header))
+	for index, header := range s.header {
+		if header.Needed() && !header.IsExportFlag() {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
 }
 
-func (s *SnowSingleExporter) ReadData(row []string) {
+// callRowHook 用导出的列组成一行数据调用行hook, 返回nil时去掉这一行
+func (s *SnowSingleExporter) callRowHook(line int, row []string, rowData []interface{}) (map[string]interface{}, bool) {
+	rowMap := make(map[string]interface{})
+	for _, index := range s.outputIndexes() {
+		rowMap[s.header[index].Key()] = rowData[index]
+	}
+	var result map[string]interface{}
+	var err error
+	if reason := catchReason(func() { result, err = s.rowHook(rowMap) }); reason != "" {
+		err = errors.New(reason)
+	}
+	if err == nil {
+		return result, result != nil
+	}
+	index := 0
+	if hookErr, ok := err.(*HookError); ok && hookErr.Field != "" {
+		for i, header := range s.header {
+			if header.Key() == hookErr.Field {
+				index = i
+				break
+			}
+		}
+	}
+	text := ""
+	if index < len(row) {
+		text = row[index]
+	}
+	s.addError(CellPos{line + 1, index + 1}, s.header[index].Key(), s.header[index].Type(), text, err.Error())
+	return nil, false
+}
+
+// hookedRow 用行hook返回的值替换导出的列, hook去掉的字段是nil; key和其他列的类型都不能被hook改变
+func (s *SnowSingleExporter) hookedRow(line int, row []string, rowData []interface{}, rowMap map[string]interface{}) ([]interface{}, bool) {
+	hooked := append([]interface{}{}, rowData...)
+	for _, index := range s.outputIndexes() {
+		hooked[index] = rowMap[s.header[index].Key()]
+	}
+	if reflect.TypeOf(hooked[0]) != reflect.TypeOf(rowData[0]) {
+		text := ""
+		if len(row) > 0 {
+			text = row[0]
+		}
+		reason := fmt.Sprintf("row hook changed key %v to %T %v, key must stay %T", rowData[0], hooked[0], hooked[0], rowData[0])
+		if hooked[0] == nil {
+			reason = fmt.Sprintf("row hook removed key %v", rowData[0])
+		}
+		s.addError(CellPos{line + 1, 1}, s.header[0].Key(), s.header[0].Type(), text, reason)
+		return nil, false
+	}
+	// 数据结构中是列定义的类型, 行hook不能改变其他列的类型
+	ok := true
+	for _, index := range s.outputIndexes() {
+		header := s.header[index]
+		if index == 0 || matchSchema(headerSchema(header), hooked[index]) {
+			continue
+		}
+		text := ""
+		if index < len(row) {
+			text = row[index]
+		}
+		reason := fmt.Sprintf("row hook changed %s to %T %v, it must match the column type %s", header.Key(), hooked[index], hooked[index], header.Type())
+		s.addError(CellPos{line + 1, index + 1}, header.Key(), header.Type(), text, reason)
+		ok = false
+	}
+	if !ok {
+		return nil, false
+	}
+	return hooked, true
+}
+
+func (s *SnowSingleExporter) ReadData(line int, row []string) {
+	if len(row) == 0 {
+		// 该行没有数据直接跳过
+		return
+	}
 	var header *Header
 	var v interface{}
+	var reason string
 	var rowData []interface{}
+	var rowErrors []int
 	for i := 0; i < len(s.header); i++ {
 		header = s.header[i]
-		if len(row) > 0 {
-			header.SetLoggerPrefix(s.dataDef.Name + ": " + row[0] + " ")
+		text := ""
+		if i < len(row) {
+			text = row[i]
 		}
-		if i >= len(row) {
-			v = header.ParseData("")
-		} else {
-			v = header.ParseData(row[i])
+		pos := CellPos{line + 1, i + 1}
+		v, reason = s.parseCell(header, text)
+		if reason != "" {
+			s.addError(pos, header.Key(), header.Type(), text, reason)
+			rowErrors = append(rowErrors, len(s.errors)-1)
+		}
+		if header.IsExportFlag() && v != true {
+			// 如果设置了导表标签并且该行不导表直接跳过这行数据, 这行的错误也不再关心,
+			// 但导表标签本身填错时要报告, 否则这一行会被悄悄跳过
+			if reason != "" {
+				rowErrors = rowErrors[:len(rowErrors)-1]
+			}
+			for j := len(rowErrors) - 1; j >= 0; j-- {
+				index := rowErrors[j]
+				s.errors = append(s.errors[:index], s.errors[index+1:]...)
+			}
+			return
 		}
-		if header.IsExportFlag() && v.(bool) == false {
-			// 如果设置了导表标签并且该行不导表直接跳过这行数据
+		rowData = append(rowData, v)
+	}
+
+	if len(rowErrors) > 0 || len(rowData) == 0 || rowData[0] == nil {
+		return
+	}
+	var rowMap map[string]interface{}
+	if s.rowHook != nil {
+		var ok bool
+		if rowMap, ok = s.callRowHook(line, row, rowData); !ok {
 			return
 		}
-		if len(row) == 0 {
-			// 该行没有数据直接跳过
+		// 范围, 引用和key都检查行hook处理之后的值
+		if rowData, ok = s.hookedRow(line, row, rowData, rowMap); !ok {
 			return
 		}
-		rowData = append(rowData, v)
 	}
+	for i, v := range rowData {
+		if i >= len(s.ranges) || s.ranges[i] == nil || !s.header[i].Needed() {
+			continue
+		}
+		text := ""
+		if i < len(row) {
+			text = row[i]
+		}
+		if s.rowHook != nil {
+			// hook去掉或者清空的值按空单元格检查, hook填写的空单元格按填写了检查
+			if v == nil || v == "" {
+				text = ""
+			} else if strings.TrimSpace(text) == "" {
+				text = fmt.Sprint(v)
+			}
+		}
+		pos := CellPos{line + 1, i + 1}
+		for _, reason := range s.ranges[i].Check(pos, text, v) {
+			s.addError(pos, s.header[i].Key(), s.header[i].Type(), text, reason)
+		}
+	}
+	for i, v := range rowData {
+		if s.header[i].HasRef() {
+			pos := CellPos{line + 1, i + 1}
+			header := s.header[i]
+			header.EachRef(v, func(target string, key string) {
+				s.refs = append(s.refs, reference{pos, header, target, key})
+			})
+		}
+	}
+	s.data = append(s.data, rowData)
+	s.dataLines = append(s.dataLines, line)
+	if s.rowHook != nil {
+		s.rowMaps = append(s.rowMaps, rowMap)
+	}
+}
 
-	if len(rowData) > 0 && rowData[0] != nil {
-		s.data = append(s.data, rowData)
+// filterTags 去掉标签和导出目标tags不匹配的字段, lua新增的字段没有标签, 总是导出
+func (s *SnowSingleExporter) filterTags(tags []string, data map[string]interface{}, keysOrder []string, isMap bool) (map[string]interface{}, []string) {
+	if len(tags) == 0 || len(s.tagged) == 0 {
+		return data, keysOrder
+	}
+	filter := func(row map[string]interface{}) map[string]interface{} {
+		filtered := make(map[string]interface{}, len(row))
+		for key, value := range row {
+			if MatchTags(s.tagged[key], tags) {
+				filtered[key] = value
+			}
+		}
+		return filtered
 	}
+	if isMap {
+		return filter(data), keysOrder
+	}
+	filteredData := make(map[string]interface{}, len(data))
+	for id, row := range data {
+		filteredData[id] = filter(row.(map[string]interface{}))
+	}
+	filteredKeys := make([]string, 0, len(keysOrder))
+	for _, key := range keysOrder {
+		if MatchTags(s.tagged[key], tags) {
+			filteredKeys = append(filteredKeys, key)
+		}
+	}
+	return filteredData, filteredKeys
 }
 
-func (s *SnowSingleExporter) WriteMapData() error {
-	if s.tool == conf.Tool_To_Json {
-		toolMan := tojson.NewToJson(s.dataDef.Name, s.outDir, s.dataDef.RowFile)
-		toolMan.WriteData(s.mapdata)
-	} else if s.tool == conf.Tool_To_Lua {
-		toolMan := tolua.NewToLua(s.dataDef.Name, s.outDir, s.dataDef.RowFile)
-		toolMan.WriteData(s.mapdata, nil, nil, true)
+// writeOutputs 把解析好的数据按每个导出目标的tags过滤后, 用导出目标的tool注册的Writer写到每个导出目标
+func (s *SnowSingleExporter) writeOutputs(data map[string]interface{}, keysOrder []string, rowsOrder []string, isMap bool) {
+	for _, output := range s.outputs {
+		data, keysOrder := s.filterTags(output.Tags, data, keysOrder, isMap)
+		writer := factory.GetWriter(output.Tool)(conf.WriteTarget{
+			Output:    output,
+			DataDef:   s.dataDef,
+			OutDir:    path.Join(output.OutDir, s.dataDef.SubPath),
+			CommitDir: path.Join(output.CommitDir, s.dataDef.SubPath),
+			FilePath:  s.filePath,
+		})
+		writer.Begin(s.schema(data, keysOrder, isMap))
+		if isMap {
+			writer.WriteMapData(data)
+		} else {
+			writer.WriteRows(data, keysOrder, rowsOrder)
+		}
+		writer.Finish()
 	}
+}
+
+func (s *SnowSingleExporter) WriteMapData() error {
+	s.writeOutputs(s.mapdata, nil, nil, true)
 	return nil
 }
 
 func (s *SnowSingleExporter) WriteData() error {
-	outputIndexes := make([]int, 0, len(s.header))
+	outputIndexes := s.outputIndexes()
 	keysOrder := make([]string, 0, len(s.header))
 	// 先确认导表列
-	for index, header := range s.header {
-		if header.Needed() && !header.IsExportFlag() {
-			outputIndexes = append(outputIndexes, index)
-			keysOrder = append(keysOrder, header.Key())
-		}
+	for _, index := range outputIndexes {
+		keysOrder = append(keysOrder, s.header[index].Key())
 	}
 
 	mapData := make(map[string]interface{})
 	rowsOrder := make([]string, 0, len(s.data))
-	for _, row := range s.data {
-		rowMap := make(map[string]interface{})
-		for _, index := range outputIndexes {
-			if index < len(row) {
-				rowMap[s.header[index].Key()] = row[index]
+	keyLines := make(map[string]int, len(s.data))
+	for i, row := range s.data {
+		var rowMap map[string]interface{}
+		if s.rowHook != nil {
+			rowMap = s.rowMaps[i]
+		} else {
+			rowMap = make(map[string]interface{})
+			for _, index := range outputIndexes {
+				if index < len(row) {
+					rowMap[s.header[index].Key()] = row[index]
+				}
 			}
 		}
+		pos := CellPos{s.dataLines[i] + 1, 1}
+		key := ""
 		switch row[0].(type) {
 		case string:
-			key := row[0].(string)
-			if _, exist := mapData[key]; exist {
-				s.logger.Panicf("duplicate key %v row %v", key, row)
-			}
-			mapData[key] = rowMap
-			rowsOrder = append(rowsOrder, key)
+			key = row[0].(string)
 		case int:
 			if row[0].(int) == 0 {
 				continue
 			}
-			key := strconv.FormatInt(int64(row[0].(int)), 10)
-			if _, exist := mapData[key]; exist {
-				s.logger.Panicf("duplicate key %v row %v", key, row)
-			}
-			mapData[key] = rowMap
-			rowsOrder = append(rowsOrder, key)
+			key = strconv.FormatInt(int64(row[0].(int)), 10)
 		default:
-			s.logger.Panicf("first column is %T %v, cannot be received.", row[0], row[0])
+			s.addError(pos, s.header[0].Key(), s.header[0].Type(), fmt.Sprint(row[0]), fmt.Sprintf("first column is %T, cannot be received.", row[0]))
+			continue
 		}
-
+		if line, exist := keyLines[key]; exist {
+			s.addError(pos, s.header[0].Key(), s.header[0].Type(), key, fmt.Sprintf("duplicate key, already defined at %s", CellPos{line + 1, 1}))
+			continue
+		}
+		keyLines[key] = s.dataLines[i]
+		mapData[key] = rowMap
+		rowsOrder = append(rowsOrder, key)
+	}
+	if len(s.errors) > 0 {
+		return s.errors
+	}
+	if s.rowHook != nil && len(mapData) > 0 {
+		// 行hook增加的字段排在最后, 所有行都去掉的字段不再导出
+		keysOrder = mergeOrder(keysOrder, rowFields(mapData))
 	}
 	s.mapdata = mapData
 	s.keysOrder = keysOrder
 	s.rowsOrder = rowsOrder
+	s.keyLines = keyLines
 
-	if s.tool == conf.Tool_To_Json {
-		toolMan := tojson.NewToJson(s.dataDef.Name, s.outDir, s.dataDef.RowFile)
-		toolMan.WriteData(mapData)
-	} else if s.tool == conf.Tool_To_Lua {
-		toolMan := tolua.NewToLua(s.dataDef.Name, s.outDir, s.dataDef.RowFile)
-		toolMan.WriteData(mapData, s.keysOrder, s.rowsOrder, false)
-	}
+	s.writeOutputs(mapData, s.keysOrder, s.rowsOrder, false)
 	return nil
 }
 
 func (s *SnowSingleExporter) WriteDataFromLua(mapData map[string]interface{}) {
-	if s.tool == conf.Tool_To_Json {
-		toolMan := tojson.NewToJson(s.dataDef.Name, s.outDir, s.dataDef.RowFile)
-		toolMan.WriteData(mapData)
-	} else if s.tool == conf.Tool_To_Lua {
-		newKeys := make(map[string]bool, 0)
-		for _, row := range mapData {
-			for key := range row.(map[string]interface{}) {
-				newKeys[key] = true
-			}
-			break
-		}
+	if !s.dataDef.IsMapData {
+		// GlobalProcess可能只给部分行增加字段, 所有行的字段都要导出
+		s.keysOrder = mergeOrder(s.keysOrder, rowFields(mapData))
+	}
 
-		keysOrder := make([]string, 0, len(mapData))
-		for _, key := range s.keysOrder {
-			if _, exist := newKeys[key]; exist {
-				keysOrder = append(keysOrder, key)
-			}
-		}
+	newRowsKey := make(map[string]bool)
+	for k := range mapData {
+		newRowsKey[k] = true
+	}
+	s.rowsOrder = mergeOrder(s.rowsOrder, newRowsKey)
 
-		for k := range newKeys {
-			exist := false
-			for _, key := range keysOrder {
-				if key == k {
-					exist = true
-					break
-				}
-			}
-			if exist == false {
-				keysOrder = append(keysOrder, k)
+	s.writeOutputs(mapData, s.keysOrder, s.rowsOrder, false)
+}
+
+// rowFields 返回所有行中出现过的字段
+func rowFields(mapData map[string]interface{}) map[string]bool {
+	fields := make(map[string]bool)
+	for _, row := range mapData {
+		if rowMap, ok := row.(map[string]interface{}); ok {
+			for key := range rowMap {
+				fields[key] = true
 			}
 		}
+	}
+	return fields
+}
 
-		s.keysOrder = keysOrder
-
-		newRowsKey := make(map[string]bool)
-		for k := range mapData {
-			newRowsKey[k] = true
-		}
-		rowsOrder := make([]string, 0, len(s.rowsOrder))
-		for _, rowKey := range s.rowsOrder {
-			if _, exist := newRowsKey[rowKey]; exist {
-				rowsOrder = append(rowsOrder, rowKey)
-			}
+// mergeOrder 保留order中仍然存在的key的顺序, 新增的key排序后追加在最后, 保证导出结果稳定
+func mergeOrder(order []string, present map[string]bool) []string {
+	merged := make([]string, 0, len(present))
+	exist := make(map[string]bool, len(present))
+	for _, key := range order {
+		if present[key] && !exist[key] {
+			merged = append(merged, key)
+			exist[key] = true
 		}
-		for k := range newRowsKey {
-			exist := false
-			for _, key := range rowsOrder {
-				if key == k {
-					exist = true
-					break
-				}
-			}
-			if exist == false {
-				rowsOrder = append(rowsOrder, k)
-			}
+	}
+	added := make([]string, 0)
+	for key := range present {
+		if !exist[key] {
+			added = append(added, key)
 		}
-		s.rowsOrder = rowsOrder
-
-		toolMan := tolua.NewToLua(s.dataDef.Name, s.outDir, s.dataDef.RowFile)
-		toolMan.WriteData(mapData, s.keysOrder, s.rowsOrder, false)
 	}
+	sort.Slice(added, func(i, j int) bool {
+		a, errA := strconv.Atoi(added[i])
+		b, errB := strconv.Atoi(added[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return added[i] < added[j]
+	})
+	return append(merged, added...)
 }
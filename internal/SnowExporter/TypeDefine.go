@@ -13,6 +13,7 @@ var ListDefine *regexp.Regexp
 var DictDefine *regexp.Regexp
 var EnumDefine *regexp.Regexp
 var FuncDefine *regexp.Regexp
+var RefDefine *regexp.Regexp
 
 func init() {
 	// 所有head类型匹配规则
@@ -40,6 +41,11 @@ func init() {
 	if FuncDefine == nil {
 		log.Panicf("regexp.MustCompile FuncDefine failed")
 	}
+	// Ref类型匹配规则
+	RefDefine = regexp.MustCompile(`^Ref\((\w+)\)$`)
+	if RefDefine == nil {
+		log.Panicf("regexp.MustCompile RefDefine failed")
+	}
 }
 
 const (
@@ -55,6 +61,7 @@ const (
 	DictPrefix = "Dict"
 	EnumPrefix = "Enum"
 	FuncPrefix = "Func"
+	RefPrefix  = "Ref"
 )
 
 const (
@@ -69,12 +76,40 @@ type HeadType struct {
 	ListIn   *HeadType
 	DictIn   map[string]*HeadType
 	EnumIn   map[string]int
+	RefTo    string
+	// RefKey Ref引用的数据的key类型, Int或Str, 解析数据之前由SnowSingleExporter设置
+	RefKey string
+}
+
+// KeyType 第一列是这个类型的数据的key类型, 和WriteData一样, 第一列是整数或者引用整数key时key是整数, 否则是字符串
+func KeyType(first *HeadType) string {
+	if first.MetaType == Int || (first.MetaType == RefPrefix && first.RefKey == Int) {
+		return Int
+	}
+	return Str
 }
 
 func (h *HeadType) IsNil() bool {
 	return h.Meta == Nil
 }
 
+// HasRef 类型中是否有引用其他表的Ref
+func (h *HeadType) HasRef() bool {
+	switch h.MetaType {
+	case RefPrefix:
+		return true
+	case ListPrefix:
+		return h.ListIn.HasRef()
+	case DictPrefix:
+		for _, in := range h.DictIn {
+			if in.HasRef() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func NewHeadType(name string, nameType string) *HeadType {
 	return &HeadType{
 		Meta:     name,
@@ -159,6 +194,18 @@ func parseSecondType(r []string) (*HeadType, interface{}) {
 		}
 		return ht, nil
 	}
+	if len(r[1]) >= len(RefPrefix) && r[1][0:len(RefPrefix)] == RefPrefix {
+		result := RefDefine.FindStringSubmatch(r[1])
+		if len(result) < 2 {
+			log.Panicf("cannot parse %v %v", r, result)
+		}
+
+		return &HeadType{
+			Meta:     r[1],
+			MetaType: RefPrefix,
+			RefTo:    result[1],
+		}, nil
+	}
 	if len(r[1]) >= len(FuncPrefix) && r[1][0:len(FuncPrefix)] == FuncPrefix {
 		return &HeadType{
 			Meta:     r[1],
@@ -0,0 +1,290 @@
+package tobin
+
+import (
+	"encoding/binary"
+	schema "exporterX/internal/Schema"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+)
+
+var kindNames = map[byte]string{
+	KindAny:   schema.Any,
+	KindInt:   schema.Int,
+	KindFloat: schema.Float,
+	KindStr:   schema.Str,
+	KindBool:  schema.Bool,
+	KindList:  schema.List,
+	KindDict:  schema.Dict,
+	KindEnum:  schema.Enum,
+	KindFunc:  schema.Func,
+}
+
+// Data Reader读出的一个数据, 值和to_json导出的内容一致
+// isMap数据的Value是每个key的值, 否则是行的key到行的对象, 整数key也转换成字符串; Keys是行的顺序
+type Data struct {
+	Table *schema.Table
+	Keys  []string
+	Value map[string]interface{}
+}
+
+// readError 读取失败时panic, 在Read中recover后返回
+type readError struct {
+	reason string
+}
+
+// Reader 读取ToBin导出的文件, 是其他语言实现读取时的参考
+type Reader struct {
+	content []byte
+	pos     int
+	strings []string
+}
+
+func ReadFile(filePath string) (*Data, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return Read(content)
+}
+
+func Read(content []byte) (data *Data, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(readError)
+			if !ok {
+				panic(r)
+			}
+			data, err = nil, fmt.Errorf("read binary data got error: %s", e.reason)
+		}
+	}()
+	r := &Reader{content: content}
+	return r.read(), nil
+}
+
+func (r *Reader) failf(format string, v ...interface{}) {
+	panic(readError{reason: fmt.Sprintf("offset %d, ", r.pos) + fmt.Sprintf(format, v...)})
+}
+
+func (r *Reader) byte() byte {
+	if r.pos >= len(r.content) {
+		r.failf("unexpected end of data")
+	}
+	b := r.content[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *Reader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.content[r.pos:])
+	if n <= 0 {
+		r.failf("bad varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *Reader) varint() int64 {
+	v, n := binary.Varint(r.content[r.pos:])
+	if n <= 0 {
+		r.failf("bad varint")
+	}
+	r.pos += n
+	return v
+}
+
+// length 数量不会超过剩下的字节数, 避免错误的数据分配过大的内存
+func (r *Reader) length() int {
+	n := r.uvarint()
+	if n > uint64(len(r.content)-r.pos) {
+		r.failf("bad length %d", n)
+	}
+	return int(n)
+}
+
+func (r *Reader) float() float64 {
+	if r.pos+8 > len(r.content) {
+		r.failf("unexpected end of data")
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(r.content[r.pos:]))
+	r.pos += 8
+	return v
+}
+
+func (r *Reader) str() string {
+	index := r.uvarint()
+	if index >= uint64(len(r.strings)) {
+		r.failf("bad string index %d", index)
+	}
+	return r.strings[index]
+}
+
+func (r *Reader) readType() *schema.Type {
+	b := r.byte()
+	kind, ok := kindNames[b]
+	if !ok {
+		r.failf("bad kind %d", b)
+	}
+	t := &schema.Type{Kind: kind}
+	switch kind {
+	case schema.Int, schema.Str:
+		if ref := r.uvarint(); ref > 0 {
+			if ref > uint64(len(r.strings)) {
+				r.failf("bad string index %d", ref-1)
+			}
+			t.RefTo = r.strings[ref-1]
+		}
+	case schema.List:
+		t.Elem = r.readType()
+	case schema.Dict:
+		t.Fields = r.readFields()
+	case schema.Enum:
+		n := r.length()
+		t.Enum = make([]schema.EnumValue, 0, n)
+		for i := 0; i < n; i++ {
+			name := r.str()
+			t.Enum = append(t.Enum, schema.EnumValue{Name: name, Value: int(r.varint())})
+		}
+	}
+	return t
+}
+
+func (r *Reader) readFields() []*schema.Field {
+	n := r.length()
+	fields := make([]*schema.Field, 0, n)
+	for i := 0; i < n; i++ {
+		name := r.str()
+		fields = append(fields, &schema.Field{Name: name, Type: r.readType()})
+	}
+	return fields
+}
+
+func (r *Reader) readValue(t *schema.Type) interface{} {
+	switch t.Kind {
+	case schema.Int, schema.Enum:
+		return int(r.varint())
+	case schema.Float:
+		return r.float()
+	case schema.Str:
+		return r.str()
+	case schema.Bool:
+		return r.byte() != 0
+	case schema.List:
+		n := r.length()
+		list := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			list = append(list, r.readValue(t.Elem))
+		}
+		return list
+	case schema.Dict:
+		return r.readObject(t.Fields)
+	}
+	return r.readAny()
+}
+
+func (r *Reader) readAny() interface{} {
+	switch tag := r.byte(); tag {
+	case TagNil:
+		return nil
+	case TagInt:
+		return int(r.varint())
+	case TagFloat:
+		return r.float()
+	case TagStr:
+		return r.str()
+	case TagFalse:
+		return false
+	case TagTrue:
+		return true
+	case TagList:
+		n := r.length()
+		list := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			list = append(list, r.readAny())
+		}
+		return list
+	case TagDict:
+		return r.readObject(nil)
+	default:
+		r.failf("bad tag %d", tag)
+	}
+	return nil
+}
+
+func (r *Reader) readObject(fields []*schema.Field) map[string]interface{} {
+	types := make(map[string]*schema.Type, len(fields))
+	for _, field := range fields {
+		types[field.Name] = field.Type
+	}
+	n := r.length()
+	object := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		index := r.uvarint()
+		if index>>1 >= uint64(len(r.strings)) {
+			r.failf("bad string index %d", index>>1)
+		}
+		key := r.strings[index>>1]
+		t, ok := types[key]
+		if !ok && index&1 == 0 {
+			r.failf("unknown field %s", key)
+		}
+		if index&1 == 0 {
+			object[key] = r.readValue(t)
+		} else {
+			object[key] = r.readAny()
+		}
+	}
+	return object
+}
+
+func (r *Reader) read() *Data {
+	if len(r.content) < len(Magic) || string(r.content[:len(Magic)]) != Magic {
+		r.failf("not a %s file", Magic)
+	}
+	r.pos = len(Magic)
+	if version := r.uvarint(); version != Version {
+		r.failf("version %d is not supported, need %d", version, Version)
+	}
+	n := r.length()
+	r.strings = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		size := r.length()
+		r.strings = append(r.strings, string(r.content[r.pos:r.pos+size]))
+		r.pos += size
+	}
+
+	table := &schema.Table{Name: r.str()}
+	flags := r.uvarint()
+	table.IsMap = flags&FlagIsMap != 0
+	if !table.IsMap {
+		table.Key = &schema.Type{Kind: schema.Str}
+		if flags&FlagIntKey != 0 {
+			table.Key = &schema.Type{Kind: schema.Int}
+		}
+	}
+	table.Fields = r.readFields()
+	data := &Data{Table: table}
+
+	if table.IsMap {
+		data.Value = r.readObject(table.Fields)
+	} else {
+		rows := r.length()
+		data.Keys = make([]string, 0, rows)
+		data.Value = make(map[string]interface{}, rows)
+		for i := 0; i < rows; i++ {
+			var key string
+			if table.IntKey() {
+				key = strconv.FormatInt(r.varint(), 10)
+			} else {
+				key = r.str()
+			}
+			data.Keys = append(data.Keys, key)
+			data.Value[key] = r.readObject(table.Fields)
+		}
+	}
+	if r.pos != len(r.content) {
+		r.failf("%d bytes left", len(r.content)-r.pos)
+	}
+	return data
+}
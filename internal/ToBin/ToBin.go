@@ -0,0 +1,352 @@
+package tobin
+
+import (
+	"bytes"
+	"encoding/binary"
+	schema "exporterX/internal/Schema"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// 文件格式, 整数都是varint, 有符号整数是zigzag编码的varint, 浮点数是8字节小端float64
+//
+//	文件:   Magic Version 字符串池 数据结构 数据
+//	字符串池: 数量 {长度 UTF-8内容}, 之后所有字符串都是池中的下标
+//	数据结构: 名字 Flags 字段数 {字段名 类型}
+//	类型:   Kind Int和Str后面是Ref的数据名下标+1(0表示没有) List后面是元素类型
+//	        Dict后面是字段数 {字段名 类型}, Enum后面是取值数 {名字 值}
+//	数据:   isMap数据是一个对象, 否则是行数 {key 对象}, FlagIntKey时key是有符号整数, 否则是字符串
+//	对象:   字段数 {字段名下标<<1|IsAny 值}, 值按数据结构中这个字段的类型编码,
+//	        IsAny为1时是Any, 数据结构中没有的字段和lua处理后和类型不一致的值都是Any
+//	Any:    一个字节的Tag, 之后是这个类型的值, List和Dict的元素也是Any
+const (
+	Magic   = "XBIN"
+	Version = 1
+)
+
+const (
+	FlagIsMap  = 1 << 0
+	FlagIntKey = 1 << 1
+)
+
+// Kind 数据结构中类型的编码
+const (
+	KindAny byte = iota
+	KindInt
+	KindFloat
+	KindStr
+	KindBool
+	KindList
+	KindDict
+	KindEnum
+	KindFunc
+)
+
+// Tag Any值的类型
+const (
+	TagNil byte = iota
+	TagInt
+	TagFloat
+	TagStr
+	TagFalse
+	TagTrue
+	TagList
+	TagDict
+)
+
+var kinds = map[string]byte{
+	schema.Any:   KindAny,
+	schema.Int:   KindInt,
+	schema.Float: KindFloat,
+	schema.Str:   KindStr,
+	schema.Bool:  KindBool,
+	schema.List:  KindList,
+	schema.Dict:  KindDict,
+	schema.Enum:  KindEnum,
+	schema.Func:  KindFunc,
+}
+
+// ToBin 按数据结构导出紧凑的二进制格式, 读取的参考实现是Reader
+type ToBin struct {
+	logger   *log.Logger
+	DataName string
+	OutPath  string
+	strings  []string
+	pool     map[string]int
+}
+
+func NewToBin(dataName string, outPath string) *ToBin {
+	logger := log.New(os.Stdout, "["+dataName+"]: ", log.Lshortfile)
+	if _, err := os.Stat(outPath); os.IsNotExist(err) {
+		os.MkdirAll(outPath, os.ModePerm)
+	}
+	return &ToBin{logger: logger, DataName: dataName, OutPath: outPath, pool: make(map[string]int)}
+}
+
+func writeUvarint(buffer *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buffer.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func writeVarint(buffer *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	buffer.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func writeFloat(buffer *bytes.Buffer, v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	buffer.Write(b[:])
+}
+
+// intern 字符串在字符串池中的下标, 相同的字符串只保存一次
+func (t *ToBin) intern(s string) int {
+	index, ok := t.pool[s]
+	if !ok {
+		index = len(t.strings)
+		t.pool[s] = index
+		t.strings = append(t.strings, s)
+	}
+	return index
+}
+
+func (t *ToBin) writeStr(buffer *bytes.Buffer, s string) {
+	writeUvarint(buffer, uint64(t.intern(s)))
+}
+
+func (t *ToBin) writeType(buffer *bytes.Buffer, ft *schema.Type) {
+	buffer.WriteByte(kinds[ft.Kind])
+	switch ft.Kind {
+	case schema.Int, schema.Str:
+		if ft.RefTo == "" {
+			writeUvarint(buffer, 0)
+		} else {
+			writeUvarint(buffer, uint64(t.intern(ft.RefTo))+1)
+		}
+	case schema.List:
+		t.writeType(buffer, ft.Elem)
+	case schema.Dict:
+		t.writeFields(buffer, ft.Fields)
+	case schema.Enum:
+		writeUvarint(buffer, uint64(len(ft.Enum)))
+		for _, value := range ft.Enum {
+			t.writeStr(buffer, value.Name)
+			writeVarint(buffer, int64(value.Value))
+		}
+	}
+}
+
+func (t *ToBin) writeFields(buffer *bytes.Buffer, fields []*schema.Field) {
+	writeUvarint(buffer, uint64(len(fields)))
+	for _, field := range fields {
+		t.writeStr(buffer, field.Name)
+		t.writeType(buffer, field.Type)
+	}
+}
+
+// toInt Int和Enum的值, lua返回的整数和浮点数也可以
+func toInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), v == math.Trunc(v)
+	}
+	return 0, false
+}
+
+// matches 值是否完全符合类型, lua处理后的数据可能和类型不一致, 比如空的Dict变成了空的List
+func matches(ft *schema.Type, value interface{}) bool {
+	switch ft.Kind {
+	case schema.Int, schema.Enum:
+		_, ok := toInt(value)
+		return ok
+	case schema.Float:
+		switch value.(type) {
+		case float64, int:
+			return true
+		}
+		return false
+	case schema.Str:
+		_, ok := value.(string)
+		return ok
+	case schema.Bool:
+		_, ok := value.(bool)
+		return ok
+	case schema.List:
+		list, ok := value.([]interface{})
+		for i := 0; ok && i < len(list); i++ {
+			ok = matches(ft.Elem, list[i])
+		}
+		return ok
+	case schema.Dict:
+		dict, ok := value.(map[string]interface{})
+		for _, field := range ft.Fields {
+			if elem, exist := dict[field.Name]; ok && exist {
+				ok = matches(field.Type, elem)
+			}
+		}
+		return ok
+	}
+	return true
+}
+
+// writeValue 按类型写一个符合类型的值, Func是数值或者[类型, 参数], 和Any一样带Tag
+func (t *ToBin) writeValue(buffer *bytes.Buffer, ft *schema.Type, value interface{}, name string) {
+	switch ft.Kind {
+	case schema.Int, schema.Enum:
+		v, _ := toInt(value)
+		writeVarint(buffer, v)
+	case schema.Float:
+		if v, ok := value.(int); ok {
+			writeFloat(buffer, float64(v))
+		} else {
+			writeFloat(buffer, value.(float64))
+		}
+	case schema.Str:
+		t.writeStr(buffer, value.(string))
+	case schema.Bool:
+		if value.(bool) {
+			buffer.WriteByte(1)
+		} else {
+			buffer.WriteByte(0)
+		}
+	case schema.List:
+		list := value.([]interface{})
+		writeUvarint(buffer, uint64(len(list)))
+		for _, elem := range list {
+			t.writeValue(buffer, ft.Elem, elem, name)
+		}
+	case schema.Dict:
+		dict := value.(map[string]interface{})
+		t.writeObject(buffer, ft.Fields, dict, sortedKeys(dict), name+".")
+	default:
+		t.writeAny(buffer, value, name)
+	}
+}
+
+func (t *ToBin) writeAny(buffer *bytes.Buffer, value interface{}, name string) {
+	switch v := value.(type) {
+	case nil:
+		buffer.WriteByte(TagNil)
+	case int:
+		buffer.WriteByte(TagInt)
+		writeVarint(buffer, int64(v))
+	case int64:
+		buffer.WriteByte(TagInt)
+		writeVarint(buffer, v)
+	case float64:
+		buffer.WriteByte(TagFloat)
+		writeFloat(buffer, v)
+	case string:
+		buffer.WriteByte(TagStr)
+		t.writeStr(buffer, v)
+	case bool:
+		if v {
+			buffer.WriteByte(TagTrue)
+		} else {
+			buffer.WriteByte(TagFalse)
+		}
+	case []interface{}:
+		buffer.WriteByte(TagList)
+		writeUvarint(buffer, uint64(len(v)))
+		for _, elem := range v {
+			t.writeAny(buffer, elem, name)
+		}
+	case map[string]interface{}:
+		buffer.WriteByte(TagDict)
+		t.writeObject(buffer, nil, v, sortedKeys(v), name+".")
+	default:
+		t.logger.Panicf("%s is %T %v, cannot write as binary", name, value, value)
+	}
+}
+
+// writeObject 按keys的顺序写对象中存在的字段, 和ToJson一样不在keys中的字段不导出
+func (t *ToBin) writeObject(buffer *bytes.Buffer, fields []*schema.Field, data map[string]interface{}, keys []string, prefix string) {
+	types := make(map[string]*schema.Type, len(fields))
+	for _, field := range fields {
+		types[field.Name] = field.Type
+	}
+	present := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := data[key]; ok {
+			present = append(present, key)
+		}
+	}
+	writeUvarint(buffer, uint64(len(present)))
+	for _, key := range present {
+		index := uint64(t.intern(key)) << 1
+		if ft, ok := types[key]; ok && matches(ft, data[key]) {
+			writeUvarint(buffer, index)
+			t.writeValue(buffer, ft, data[key], prefix+key)
+		} else {
+			writeUvarint(buffer, index|1)
+			t.writeAny(buffer, data[key], prefix+key)
+		}
+	}
+}
+
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteData 生成<DataName>.bin, 行按rowsOrder的顺序, 字段按keysOrder的顺序; rowFile的数据也只有一个文件
+func (t *ToBin) WriteData(table *schema.Table, data map[string]interface{}, keysOrder []string, rowsOrder []string) {
+	var schemaBuffer, dataBuffer bytes.Buffer
+	t.writeStr(&schemaBuffer, table.Name)
+	flags := uint64(0)
+	if table.IsMap {
+		flags |= FlagIsMap
+	}
+	if table.IntKey() {
+		flags |= FlagIntKey
+	}
+	writeUvarint(&schemaBuffer, flags)
+	t.writeFields(&schemaBuffer, table.Fields)
+
+	if table.IsMap {
+		t.writeObject(&dataBuffer, table.Fields, data, sortedKeys(data), "")
+	} else {
+		writeUvarint(&dataBuffer, uint64(len(rowsOrder)))
+		for _, id := range rowsOrder {
+			if table.IntKey() {
+				key, err := strconv.ParseInt(id, 10, 64)
+				if err != nil {
+					t.logger.Panicf("row %s is not an integer key", id)
+				}
+				writeVarint(&dataBuffer, key)
+			} else {
+				t.writeStr(&dataBuffer, id)
+			}
+			t.writeObject(&dataBuffer, table.Fields, data[id].(map[string]interface{}), keysOrder, id+".")
+		}
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(Magic)
+	writeUvarint(&buffer, Version)
+	writeUvarint(&buffer, uint64(len(t.strings)))
+	for _, s := range t.strings {
+		writeUvarint(&buffer, uint64(len(s)))
+		buffer.WriteString(s)
+	}
+	buffer.Write(schemaBuffer.Bytes())
+	buffer.Write(dataBuffer.Bytes())
+
+	filePath := path.Join(t.OutPath, t.DataName+".bin")
+	if err := ioutil.WriteFile(filePath, buffer.Bytes(), 0644); err != nil {
+		t.logger.Panicf(err.Error())
+	}
+}
@@ -0,0 +1,223 @@
+package tobin
+
+import (
+	"encoding/json"
+	schema "exporterX/internal/Schema"
+	tojson "exporterX/internal/ToJson"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"testing"
+)
+
+var (
+	intType   = &schema.Type{Kind: schema.Int}
+	floatType = &schema.Type{Kind: schema.Float}
+	strType   = &schema.Type{Kind: schema.Str}
+	boolType  = &schema.Type{Kind: schema.Bool}
+	infoType  = &schema.Type{Kind: schema.Dict, Fields: []*schema.Field{
+		{Name: "a", Type: intType},
+		{Name: "b", Type: strType},
+		{Name: "c", Type: &schema.Type{Kind: schema.Float}},
+	}}
+	elemType = &schema.Type{Kind: schema.Enum, Enum: []schema.EnumValue{
+		{Name: "None", Value: 0}, {Name: "Fire", Value: 1}, {Name: "Water", Value: -2},
+	}}
+)
+
+// rowFields 覆盖所有类型的字段, Extra不在数据结构中
+var rowFields = []*schema.Field{
+	{Name: "Level", Type: intType},
+	{Name: "Rate", Type: floatType},
+	{Name: "Name", Type: strType},
+	{Name: "Boss", Type: boolType},
+	{Name: "Drops", Type: &schema.Type{Kind: schema.List, Elem: intType}},
+	{Name: "Groups", Type: &schema.Type{Kind: schema.List, Elem: &schema.Type{Kind: schema.List, Elem: strType}}},
+	{Name: "Info", Type: infoType},
+	{Name: "Infos", Type: &schema.Type{Kind: schema.List, Elem: infoType}},
+	{Name: "Elem", Type: elemType},
+	{Name: "Grow", Type: &schema.Type{Kind: schema.Func}},
+	{Name: "Tpl", Type: &schema.Type{Kind: schema.Str, RefTo: "TemplateData"}},
+	{Name: "Hooked", Type: &schema.Type{Kind: schema.Any}},
+}
+
+var rowKeys = []string{"Level", "Rate", "Name", "Boss", "Drops", "Groups", "Info", "Infos", "Elem", "Grow", "Tpl", "Hooked", "Extra"}
+
+// rows 第二行是lua处理后和类型不一致的值, 都按Any导出
+func rows() []map[string]interface{} {
+	return []map[string]interface{}{
+		{
+			"Level":  3,
+			"Rate":   0.5,
+			"Name":   "say \"hi\"\n中文",
+			"Boss":   true,
+			"Drops":  []interface{}{1, -2, 1 << 40},
+			"Groups": []interface{}{[]interface{}{"a", ""}, []interface{}{}},
+			"Info":   map[string]interface{}{"a": 1, "b": "x", "c": 2},
+			"Infos":  []interface{}{map[string]interface{}{"a": 2}, map[string]interface{}{"b": "y"}},
+			"Elem":   -2,
+			"Grow":   []interface{}{"Func1", "x*x+1"},
+			"Tpl":    "one",
+			"Hooked": map[string]interface{}{"k": []interface{}{1, "two", 3.5, false}},
+		},
+		{
+			"Level":  "high",
+			"Rate":   "fast",
+			"Name":   12,
+			"Boss":   0,
+			"Drops":  []interface{}{1, "two"},
+			"Groups": map[string]interface{}{},
+			"Info":   []interface{}{},
+			"Infos":  []interface{}{map[string]interface{}{"a": "bad"}},
+			"Elem":   1.5,
+			"Grow":   3,
+			"Tpl":    nil,
+			"Hooked": nil,
+			"Extra":  []interface{}{nil, true, map[string]interface{}{"n": 1.25}},
+		},
+		{
+			"Level": 2.0,
+			"Rate":  1,
+			"Boss":  false,
+			"Extra": "only here",
+		},
+	}
+}
+
+// normalize 转成json再读回来, 和to_json导出的值用同样的类型比较
+func normalize(t *testing.T, value interface{}) interface{} {
+	t.Helper()
+	content, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("marshal %v got error: %s", value, err)
+	}
+	var result interface{}
+	if err := json.Unmarshal(content, &result); err != nil {
+		t.Fatalf("unmarshal %s got error: %s", content, err)
+	}
+	return result
+}
+
+// loadJson 用to_json导出同样的数据, 返回读回来的值
+func loadJson(t *testing.T, name string, data map[string]interface{}, keysOrder []string, rowsOrder []string, isMap bool) interface{} {
+	t.Helper()
+	dir := t.TempDir()
+	tojson.NewToJson(name, dir, false, true).WriteData(data, keysOrder, rowsOrder, isMap)
+	content, err := ioutil.ReadFile(path.Join(dir, name+".json"))
+	if err != nil {
+		t.Fatalf("read %s.json got error: %s", name, err)
+	}
+	var result interface{}
+	if err := json.Unmarshal(content, &result); err != nil {
+		t.Fatalf("load %s.json got error: %s", name, err)
+	}
+	return result
+}
+
+// roundTrip 用ToBin导出再用Reader读回来, 数据结构和值都要和导出的一致
+func roundTrip(t *testing.T, table *schema.Table, data map[string]interface{}, keysOrder []string, rowsOrder []string) *Data {
+	t.Helper()
+	dir := t.TempDir()
+	NewToBin(table.Name, dir).WriteData(table, data, keysOrder, rowsOrder)
+	result, err := ReadFile(path.Join(dir, table.Name+".bin"))
+	if err != nil {
+		t.Fatalf("read %s.bin got error: %s", table.Name, err)
+	}
+	if result.Table.Name != table.Name || result.Table.IsMap != table.IsMap || result.Table.IntKey() != table.IntKey() {
+		t.Errorf("table %+v reads back as %+v", table, result.Table)
+	}
+	if !reflect.DeepEqual(result.Table.Fields, table.Fields) {
+		t.Errorf("%s fields do not read back", table.Name)
+	}
+	if !table.IsMap && !reflect.DeepEqual(result.Keys, rowsOrder) {
+		t.Errorf("%s rows read back as %v, want %v", table.Name, result.Keys, rowsOrder)
+	}
+	want := loadJson(t, table.Name, data, keysOrder, rowsOrder, table.IsMap)
+	if got := normalize(t, result.Value); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s reads back as %v, to_json exports %v", table.Name, got, want)
+	}
+	return result
+}
+
+func TestIntKeyRows(t *testing.T) {
+	table := &schema.Table{Name: "MonsterData", Key: intType, Fields: rowFields}
+	data := make(map[string]interface{})
+	rowsOrder := []string{"1002", "-7", "1001"}
+	for i, row := range rows() {
+		data[rowsOrder[i]] = row
+	}
+	result := roundTrip(t, table, data, rowKeys, rowsOrder)
+
+	// 符合类型的值按类型读回来, Int和Enum是int
+	first := result.Value["1002"].(map[string]interface{})
+	if first["Level"] != 3 || first["Elem"] != -2 || first["Boss"] != true {
+		t.Errorf("typed values read back as %v", first)
+	}
+	second := result.Value["-7"].(map[string]interface{})
+	if second["Level"] != "high" || second["Grow"] != 3 || second["Tpl"] != nil {
+		t.Errorf("Any values read back as %v", second)
+	}
+}
+
+func TestStrKeyRows(t *testing.T) {
+	table := &schema.Table{Name: "ItemData", Key: strType, Fields: rowFields}
+	data := make(map[string]interface{})
+	rowsOrder := []string{"sword", "1001", ""}
+	for i, row := range rows() {
+		data[rowsOrder[i]] = row
+	}
+	roundTrip(t, table, data, rowKeys, rowsOrder)
+}
+
+func TestKeysOrderFiltersFields(t *testing.T) {
+	table := &schema.Table{Name: "TagData", Key: intType, Fields: rowFields[:2]}
+	data := map[string]interface{}{
+		"1": map[string]interface{}{"Level": 1, "Rate": 0.25, "Secret": "dropped"},
+	}
+	result := roundTrip(t, table, data, []string{"Rate", "Level"}, []string{"1"})
+	if _, ok := result.Value["1"].(map[string]interface{})["Secret"]; ok {
+		t.Errorf("field not in keysOrder is exported")
+	}
+}
+
+func TestMapData(t *testing.T) {
+	table := &schema.Table{Name: "ParamsData", IsMap: true, Fields: []*schema.Field{
+		{Name: "MaxLevel", Type: intType},
+		{Name: "Names", Type: &schema.Type{Kind: schema.List, Elem: strType}},
+		{Name: "Info", Type: infoType},
+		{Name: "Open", Type: boolType},
+		{Name: "Elem", Type: elemType},
+		{Name: "Grow", Type: &schema.Type{Kind: schema.Func}},
+		{Name: "Rate", Type: floatType},
+	}}
+	data := map[string]interface{}{
+		"MaxLevel": 100,
+		"Names":    []interface{}{"a", "b"},
+		"Info":     map[string]interface{}{"a": 1, "b": "x"},
+		"Open":     "yes",
+		"Elem":     1,
+		"Grow":     []interface{}{"Awaken", []interface{}{1.5, 2}},
+		"Rate":     -0.125,
+		"Added":    map[string]interface{}{"by": "lua"},
+	}
+	roundTrip(t, table, data, nil, nil)
+}
+
+func TestReadBadData(t *testing.T) {
+	dir := t.TempDir()
+	table := &schema.Table{Name: "BadData", Key: intType, Fields: rowFields[:1]}
+	NewToBin(table.Name, dir).WriteData(table, map[string]interface{}{"1": map[string]interface{}{"Level": 1}}, rowKeys, []string{"1"})
+	content, err := ioutil.ReadFile(path.Join(dir, "BadData.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range [][]byte{
+		[]byte("JSON"),
+		content[:len(content)-1],
+		append(append([]byte{}, content...), 0),
+	} {
+		if _, err := Read(bad); err == nil {
+			t.Errorf("read %v got no error", bad)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package tobin
+
+import (
+	exporter "exporterX/DataExporter"
+	factory "exporterX/DataExporter/Factory"
+	schema "exporterX/internal/Schema"
+)
+
+func init() {
+	factory.RegisterWriter(exporter.Tool_To_Bin, func(target exporter.WriteTarget) exporter.Writer {
+		return &binWriter{ToBin: NewToBin(target.DataDef.Name, target.OutDir)}
+	})
+}
+
+// binWriter 文件开头是数据结构, 保存Begin中的table和数据一起写
+type binWriter struct {
+	*ToBin
+	table *schema.Table
+}
+
+func (w *binWriter) Begin(table *schema.Table) {
+	w.table = table
+}
+
+func (w *binWriter) WriteMapData(data map[string]interface{}) {
+	w.WriteData(w.table, data, nil, nil)
+}
+
+func (w *binWriter) WriteRows(data map[string]interface{}, keysOrder []string, rowsOrder []string) {
+	w.WriteData(w.table, data, keysOrder, rowsOrder)
+}
+
+func (w *binWriter) Finish() {}
@@ -0,0 +1,240 @@
+package tocsharp
+
+import (
+	"bytes"
+	schema "exporterX/internal/Schema"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const DefaultNamespace = "DataTables"
+
+// csKeywords C#的保留字, 作为名字时前面加@
+var csKeywords = map[string]bool{
+	"abstract": true, "as": true, "base": true, "bool": true, "break": true, "byte": true,
+	"case": true, "catch": true, "char": true, "checked": true, "class": true, "const": true,
+	"continue": true, "decimal": true, "default": true, "delegate": true, "do": true, "double": true,
+	"else": true, "enum": true, "event": true, "explicit": true, "extern": true, "false": true,
+	"finally": true, "fixed": true, "float": true, "for": true, "foreach": true, "goto": true,
+	"if": true, "implicit": true, "in": true, "int": true, "interface": true, "internal": true,
+	"is": true, "lock": true, "long": true, "namespace": true, "new": true, "null": true,
+	"object": true, "operator": true, "out": true, "override": true, "params": true, "private": true,
+	"protected": true, "public": true, "readonly": true, "ref": true, "return": true, "sbyte": true,
+	"sealed": true, "short": true, "sizeof": true, "stackalloc": true, "static": true, "string": true,
+	"struct": true, "switch": true, "this": true, "throw": true, "true": true, "try": true,
+	"typeof": true, "uint": true, "ulong": true, "unchecked": true, "unsafe": true, "ushort": true,
+	"using": true, "virtual": true, "void": true, "volatile": true, "while": true,
+}
+
+var csIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var csInvalidChar = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ToCSharp 根据数据结构生成C#类和读取to_json导出结果的Load函数
+type ToCSharp struct {
+	logger    *log.Logger
+	DataName  string
+	OutPath   string
+	Namespace string
+	buffer    bytes.Buffer
+	indent    int
+	// types 按字段路径记录的嵌套类型名, members 数据类中已经使用的名字, 嵌套类型和属性都是数据类的成员
+	types   map[string]string
+	members map[string]bool
+}
+
+func NewToCSharp(dataName string, outPath string, namespace string) *ToCSharp {
+	logger := log.New(os.Stdout, "["+dataName+"]: ", log.Lshortfile)
+	if _, err := os.Stat(outPath); os.IsNotExist(err) {
+		os.MkdirAll(outPath, os.ModePerm)
+	}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return &ToCSharp{logger: logger, DataName: dataName, OutPath: outPath, Namespace: namespace}
+}
+
+// identifier 不能作为C#名字的字符换成_, 保留字前面加@, 和所在类同名时后面加_
+func identifier(name string, owner string) string {
+	if !csIdentifier.MatchString(name) {
+		name = csInvalidChar.ReplaceAllString(name, "_")
+		if name == "" || (name[0] >= '0' && name[0] <= '9') {
+			name = "_" + name
+		}
+	}
+	if name == owner {
+		return name + "_"
+	}
+	if csKeywords[name] {
+		return "@" + name
+	}
+	return name
+}
+
+// unique 和used中的名字重复时后面加_, 直到不再重复
+func unique(name string, used map[string]bool) string {
+	for used[name] {
+		name += "_"
+	}
+	used[name] = true
+	return name
+}
+
+func (t *ToCSharp) line(format string, v ...interface{}) {
+	if format == "" {
+		t.buffer.WriteString("\n")
+		return
+	}
+	t.buffer.WriteString(strings.Repeat("    ", t.indent))
+	t.buffer.WriteString(fmt.Sprintf(format, v...))
+	t.buffer.WriteString("\n")
+}
+
+// typeName 字段的C#类型, Dict和Enum是按字段路径命名的嵌套类型
+func (t *ToCSharp) typeName(ft *schema.Type, path []string) string {
+	switch ft.Kind {
+	case schema.Int:
+		return "int"
+	case schema.Float:
+		return "float"
+	case schema.Str:
+		return "string"
+	case schema.Bool:
+		return "bool"
+	case schema.List:
+		return "List<" + t.typeName(ft.Elem, path) + ">"
+	case schema.Dict:
+		return t.nestedName(path, "Dict")
+	case schema.Enum:
+		return t.nestedName(path, "Enum")
+	}
+	// Func是数字或者[类型, 参数]的数组, 和没有类型定义的字段一样直接使用JToken
+	return "JToken"
+}
+
+// nestedName 嵌套类型第一次用到时命名, 和数据类中的其他成员同名时后面加_
+func (t *ToCSharp) nestedName(path []string, suffix string) string {
+	key := strings.Join(path, "\x00")
+	if name, ok := t.types[key]; ok {
+		return name
+	}
+	name := unique(identifier(schema.TypeName(path...)+suffix, ""), t.members)
+	t.types[key] = name
+	return name
+}
+
+// writeNested 先声明字段类型中用到的嵌套Enum和Dict类型
+func (t *ToCSharp) writeNested(ft *schema.Type, path []string) {
+	switch ft.Kind {
+	case schema.List:
+		t.writeNested(ft.Elem, path)
+	case schema.Enum:
+		t.line("public enum %s", t.typeName(ft, path))
+		t.line("{")
+		t.indent++
+		values := make(map[string]bool, len(ft.Enum))
+		for _, value := range ft.Enum {
+			t.line("%s = %d,", unique(identifier(value.Name, ""), values), value.Value)
+		}
+		t.indent--
+		t.line("}")
+		t.line("")
+	case schema.Dict:
+		for _, field := range ft.Fields {
+			t.writeNested(field.Type, append(path, field.Name))
+		}
+		name := t.typeName(ft, path)
+		t.line("public class %s", name)
+		t.line("{")
+		t.indent++
+		t.writeFields(ft.Fields, path, name)
+		t.indent--
+		t.line("}")
+		t.line("")
+	}
+}
+
+// writeFields 属性名和类中的其他成员同名时后面加_, 数据类的成员是t.members, Dict类只有自己的属性
+func (t *ToCSharp) writeFields(fields []*schema.Field, path []string, owner string) {
+	used := t.members
+	if path != nil {
+		used = map[string]bool{owner: true}
+	}
+	for i, field := range fields {
+		if i > 0 {
+			t.line("")
+		}
+		if ref := field.Type.Ref(); ref != "" {
+			t.line("/// <summary>Ref(%s)</summary>", ref)
+		}
+		t.line("[JsonProperty(%q)]", field.Name)
+		t.line("public %s %s { get; set; }", t.typeName(field.Type, append(path, field.Name)), unique(identifier(field.Name, owner), used))
+	}
+}
+
+// WriteSchema 生成<DataName>.cs, 每个数据一个类, 字段顺序和导出数据一致, 保证多次生成的结果相同
+func (t *ToCSharp) WriteSchema(table *schema.Table) {
+	className := identifier(table.Name, "")
+	// 生成的方法名也是数据类的成员
+	t.types = make(map[string]string)
+	t.members = map[string]bool{className: true, "Load": true, "LoadRow": true, "LoadIndex": true}
+	t.line("// Code generated by exporterX from %s. DO NOT EDIT.", table.Name)
+	t.line("using System.Collections.Generic;")
+	t.line("using Newtonsoft.Json;")
+	t.line("using Newtonsoft.Json.Linq;")
+	t.line("")
+	t.line("namespace %s", t.Namespace)
+	t.line("{")
+	t.indent++
+	t.line("public class %s", className)
+	t.line("{")
+	t.indent++
+	for _, field := range table.Fields {
+		t.writeNested(field.Type, []string{field.Name})
+	}
+	t.writeFields(table.Fields, nil, className)
+	t.line("")
+
+	if table.IsMap {
+		t.line("public static %s Load(string json)", className)
+		t.line("{")
+		t.line("    return JsonConvert.DeserializeObject<%s>(json);", className)
+		t.line("}")
+	} else {
+		keyType := "string"
+		if table.IntKey() {
+			keyType = "int"
+		}
+		if table.RowFile {
+			t.line("/// <summary>Load one row from %s/{id}.json</summary>", table.Name)
+			t.line("public static %s LoadRow(string json)", className)
+			t.line("{")
+			t.line("    return JsonConvert.DeserializeObject<%s>(json);", className)
+			t.line("}")
+			t.line("")
+			t.line("/// <summary>Load the row ids from %s/index.json</summary>", table.Name)
+			t.line("public static List<%s> LoadIndex(string json)", keyType)
+			t.line("{")
+			t.line("    return JsonConvert.DeserializeObject<List<%s>>(json);", keyType)
+			t.line("}")
+		} else {
+			t.line("public static Dictionary<%s, %s> Load(string json)", keyType, className)
+			t.line("{")
+			t.line("    return JsonConvert.DeserializeObject<Dictionary<%s, %s>>(json);", keyType, className)
+			t.line("}")
+		}
+	}
+	t.indent--
+	t.line("}")
+	t.indent--
+	t.line("}")
+
+	filePath := path.Join(t.OutPath, t.DataName+".cs")
+	if err := ioutil.WriteFile(filePath, t.buffer.Bytes(), 0644); err != nil {
+		t.logger.Panicf(err.Error())
+	}
+}
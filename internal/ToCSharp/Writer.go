@@ -0,0 +1,24 @@
+package tocsharp
+
+import (
+	exporter "exporterX/DataExporter"
+	factory "exporterX/DataExporter/Factory"
+	schema "exporterX/internal/Schema"
+)
+
+func init() {
+	factory.RegisterWriter(exporter.Tool_To_CSharp, func(target exporter.WriteTarget) exporter.Writer {
+		return NewToCSharp(target.DataDef.Name, target.OutDir, target.Output.Package)
+	})
+}
+
+// Begin 只根据数据结构生成代码, 不写数据
+func (t *ToCSharp) Begin(table *schema.Table) {
+	t.WriteSchema(table)
+}
+
+func (t *ToCSharp) WriteMapData(data map[string]interface{}) {}
+
+func (t *ToCSharp) WriteRows(data map[string]interface{}, keysOrder []string, rowsOrder []string) {}
+
+func (t *ToCSharp) Finish() {}
@@ -0,0 +1,115 @@
+package tocbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	layout "exporterX/internal/Layout"
+	"math"
+)
+
+// CBOR的major type
+const (
+	majorUint  = 0
+	majorNeg   = 1
+	majorText  = 3
+	majorArray = 4
+	majorMap   = 5
+)
+
+// ToCbor 导出CBOR(RFC 8949), 结构和ToJson完全一致, 行的key也是字符串
+type ToCbor struct {
+	*layout.Writer
+}
+
+func NewToCbor(dataName string, outPath string, oneRowOneFile bool) *ToCbor {
+	return &ToCbor{layout.NewWriter(dataName, outPath, oneRowOneFile, cborEncoder{})}
+}
+
+type cborEncoder struct{}
+
+func (e cborEncoder) Name() string { return "cbor" }
+
+func (e cborEncoder) Ext() string { return ".cbor" }
+
+func (e cborEncoder) BeginMap(buffer *bytes.Buffer, n int) {
+	writeHead(buffer, majorMap, uint64(n))
+}
+
+func (e cborEncoder) Key(buffer *bytes.Buffer, i int, key string) {
+	writeString(buffer, key)
+}
+
+func (e cborEncoder) EndMap(buffer *bytes.Buffer) {}
+
+func (e cborEncoder) BeginList(buffer *bytes.Buffer, n int) {
+	writeHead(buffer, majorArray, uint64(n))
+}
+
+func (e cborEncoder) Elem(buffer *bytes.Buffer, i int) {}
+
+func (e cborEncoder) EndList(buffer *bytes.Buffer) {}
+
+func (e cborEncoder) Scalar(buffer *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buffer.WriteByte(0xf6)
+	case bool:
+		if v {
+			buffer.WriteByte(0xf5)
+		} else {
+			buffer.WriteByte(0xf4)
+		}
+	case int:
+		writeInt(buffer, int64(v))
+	case int64:
+		writeInt(buffer, v)
+	case float64:
+		buffer.WriteByte(0xfb)
+		binary.Write(buffer, binary.BigEndian, math.Float64bits(v))
+	case string:
+		writeString(buffer, v)
+	default:
+		return errors.New("unsupported type")
+	}
+	return nil
+}
+
+func (e cborEncoder) Format(content []byte) ([]byte, error) {
+	return content, nil
+}
+
+// writeHead 使用能表示n的最短的格式
+func writeHead(buffer *bytes.Buffer, major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		buffer.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buffer.WriteByte(major | 24)
+		buffer.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buffer.WriteByte(major | 25)
+		binary.Write(buffer, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buffer.WriteByte(major | 26)
+		binary.Write(buffer, binary.BigEndian, uint32(n))
+	default:
+		buffer.WriteByte(major | 27)
+		binary.Write(buffer, binary.BigEndian, n)
+	}
+}
+
+// writeInt 负数v编码为-1-v
+func writeInt(buffer *bytes.Buffer, v int64) {
+	if v >= 0 {
+		writeHead(buffer, majorUint, uint64(v))
+	} else {
+		writeHead(buffer, majorNeg, uint64(-1-v))
+	}
+}
+
+func writeString(buffer *bytes.Buffer, s string) {
+	writeHead(buffer, majorText, uint64(len(s)))
+	buffer.WriteString(s)
+}
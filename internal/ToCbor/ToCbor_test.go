@@ -0,0 +1,215 @@
+package tocbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	schema "exporterX/internal/Schema"
+	"io/ioutil"
+	"math"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// decoder 只支持ToCbor会写出的格式, 整数都读成int
+type decoder struct {
+	t       *testing.T
+	content []byte
+}
+
+func (d *decoder) next(n int) []byte {
+	d.t.Helper()
+	if len(d.content) < n {
+		d.t.Fatalf("need %d bytes, only %d left", n, len(d.content))
+	}
+	b := d.content[:n]
+	d.content = d.content[n:]
+	return b
+}
+
+// argument 读取头中的长度或整数
+func (d *decoder) argument(info byte) uint64 {
+	d.t.Helper()
+	switch {
+	case info < 24:
+		return uint64(info)
+	case info == 24:
+		return uint64(d.next(1)[0])
+	case info == 25:
+		return uint64(binary.BigEndian.Uint16(d.next(2)))
+	case info == 26:
+		return uint64(binary.BigEndian.Uint32(d.next(4)))
+	case info == 27:
+		return binary.BigEndian.Uint64(d.next(8))
+	}
+	d.t.Fatalf("unknown additional information %d", info)
+	return 0
+}
+
+func (d *decoder) value() interface{} {
+	d.t.Helper()
+	code := d.next(1)[0]
+	major, info := code>>5, code&0x1f
+	switch major {
+	case majorUint:
+		return int(d.argument(info))
+	case majorNeg:
+		return -1 - int(d.argument(info))
+	case majorText:
+		return string(d.next(int(d.argument(info))))
+	case majorArray:
+		list := make([]interface{}, d.argument(info))
+		for i := range list {
+			list[i] = d.value()
+		}
+		return list
+	case majorMap:
+		n := int(d.argument(info))
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, ok := d.value().(string)
+			if !ok {
+				d.t.Fatalf("map key is not a string")
+			}
+			m[key] = d.value()
+		}
+		return m
+	}
+	switch code {
+	case 0xf4:
+		return false
+	case 0xf5:
+		return true
+	case 0xf6:
+		return nil
+	case 0xfb:
+		return math.Float64frombits(binary.BigEndian.Uint64(d.next(8)))
+	}
+	d.t.Fatalf("unknown code 0x%x", code)
+	return nil
+}
+
+// loadCbor 读取导出的文件, 文件必须正好是一个值
+func loadCbor(t *testing.T, filePath string) interface{} {
+	t.Helper()
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("read %s got error: %s", filePath, err)
+	}
+	d := &decoder{t: t, content: content}
+	v := d.value()
+	if len(d.content) > 0 {
+		t.Fatalf("%s has %d bytes after the value", filePath, len(d.content))
+	}
+	return v
+}
+
+func makeList(n int) []interface{} {
+	list := make([]interface{}, n)
+	for i := range list {
+		list[i] = i - n/2
+	}
+	return list
+}
+
+func makeMap(n int) map[string]interface{} {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		m["k"+strconv.Itoa(i)] = i
+	}
+	return m
+}
+
+func TestWriteHead(t *testing.T) {
+	cases := []struct {
+		n    uint64
+		want []byte
+	}{
+		{23, []byte{0x97}},
+		{24, []byte{0x98, 0x18}},
+		{255, []byte{0x98, 0xff}},
+		{256, []byte{0x99, 0x01, 0x00}},
+		{65535, []byte{0x99, 0xff, 0xff}},
+		{65536, []byte{0x9a, 0x00, 0x01, 0x00, 0x00}},
+		{math.MaxUint32 + 1, []byte{0x9b, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}},
+	}
+	for _, c := range cases {
+		var buffer bytes.Buffer
+		writeHead(&buffer, majorArray, c.n)
+		if !bytes.Equal(buffer.Bytes(), c.want) {
+			t.Errorf("array head of %d is % x, want % x", c.n, buffer.Bytes(), c.want)
+		}
+	}
+}
+
+func TestWriteMapDataRoundTrip(t *testing.T) {
+	data := map[string]interface{}{
+		"Ints": []interface{}{0, 23, 24, 255, 256, 65535, 65536, math.MaxUint32, math.MaxUint32 + 1, math.MaxInt64,
+			-1, -24, -25, -256, -257, -65536, -65537, math.MinInt32, math.MinInt32 - 1, math.MinInt64},
+		"Floats":  []interface{}{0.0, 0.5, -1.25, 1e300, -math.MaxFloat64, math.SmallestNonzeroFloat64},
+		"Strs":    []interface{}{"", "中文", string(make([]byte, 24)), string(make([]byte, 256)), string(make([]byte, 65536))},
+		"Others":  []interface{}{nil, true, false},
+		"List16":  makeList(16),
+		"List24":  makeList(24),
+		"List64K": makeList(65536),
+		"Map16":   makeMap(16),
+		"Map24":   makeMap(24),
+		"Map64K":  makeMap(65536),
+	}
+	dir := t.TempDir()
+	NewToCbor("BigMap", dir, false).WriteData(data, nil, nil, true)
+
+	got := loadCbor(t, path.Join(dir, "BigMap.cbor"))
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("BigMap.cbor does not load back as the written data")
+	}
+}
+
+func TestWriteRowsRoundTrip(t *testing.T) {
+	data := map[string]interface{}{
+		"1": map[string]interface{}{"Id": 1, "Name": "a", "Extra": -7},
+		"2": map[string]interface{}{"Id": 2, "Name": "b"},
+	}
+	dir := t.TempDir()
+	NewToCbor("RowData", dir, false).WriteData(data, []string{"Id", "Name"}, []string{"2", "1"}, false)
+
+	want := map[string]interface{}{
+		"1": map[string]interface{}{"Id": 1, "Name": "a"},
+		"2": map[string]interface{}{"Id": 2, "Name": "b"},
+	}
+	got := loadCbor(t, path.Join(dir, "RowData.cbor"))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RowData.cbor loads back as %v, want %v", got, want)
+	}
+}
+
+func TestRowFileIndexes(t *testing.T) {
+	cases := []struct {
+		key  *schema.Type
+		rows []string
+		want []interface{}
+	}{
+		{&schema.Type{Kind: schema.Str}, []string{"1001", "sword", "20"}, []interface{}{"1001", "20", "sword"}},
+		{&schema.Type{Kind: schema.Int}, []string{"1001", "-7", "20"}, []interface{}{-7, 20, 1001}},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		data := make(map[string]interface{})
+		for _, id := range c.rows {
+			data[id] = map[string]interface{}{"Name": id}
+		}
+		writer := NewToCbor("RowData", dir, true)
+		writer.Begin(&schema.Table{Name: "RowData", Key: c.key})
+		writer.WriteRows(data, []string{"Name"}, c.rows)
+
+		got := loadCbor(t, path.Join(dir, "RowData", "index.cbor"))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s key index.cbor loads back as %v, want %v", c.key.Kind, got, c.want)
+		}
+		row := loadCbor(t, path.Join(dir, "RowData", c.rows[1]+".cbor"))
+		if !reflect.DeepEqual(row, data[c.rows[1]]) {
+			t.Errorf("%s.cbor loads back as %v", c.rows[1], row)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package tocbor
+
+import (
+	exporter "exporterX/DataExporter"
+	factory "exporterX/DataExporter/Factory"
+)
+
+func init() {
+	factory.RegisterWriter(exporter.Tool_To_Cbor, func(target exporter.WriteTarget) exporter.Writer {
+		return NewToCbor(target.DataDef.Name, target.OutDir, target.DataDef.RowFile)
+	})
+}
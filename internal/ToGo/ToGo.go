@@ -0,0 +1,198 @@
+package togo
+
+import (
+	"bytes"
+	schema "exporterX/internal/Schema"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const DefaultPackage = "datatables"
+
+var goInvalidChar = regexp.MustCompile(`[^\pL\pN_]`)
+
+// ToGo 根据数据结构生成Go结构体和读取to_json导出结果的Load函数
+type ToGo struct {
+	logger   *log.Logger
+	DataName string
+	OutPath  string
+	Package  string
+	buffer   bytes.Buffer
+}
+
+func NewToGo(dataName string, outPath string, pkg string) *ToGo {
+	logger := log.New(os.Stdout, "["+dataName+"]: ", log.Lshortfile)
+	if _, err := os.Stat(outPath); os.IsNotExist(err) {
+		os.MkdirAll(outPath, os.ModePerm)
+	}
+	if pkg == "" {
+		pkg = DefaultPackage
+	}
+	return &ToGo{logger: logger, DataName: dataName, OutPath: outPath, Package: pkg}
+}
+
+// exported 转换成导出的Go名字, 不能用的字符换成_, 不是大写字母开头时前面加X
+func exported(name string) string {
+	name = schema.TypeName(goInvalidChar.ReplaceAllString(name, "_"))
+	if r, _ := utf8.DecodeRuneInString(name); !unicode.IsUpper(r) {
+		name = "X" + name
+	}
+	return name
+}
+
+// fieldNames 结构体字段名, 转换后重名的字段后面加序号
+func fieldNames(fields []*schema.Field) []string {
+	names := make([]string, 0, len(fields))
+	seen := make(map[string]bool, len(fields))
+	for i, field := range fields {
+		name := exported(field.Name)
+		if seen[name] {
+			name = fmt.Sprintf("%s%d", name, i)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+func (t *ToGo) printf(format string, v ...interface{}) {
+	t.buffer.WriteString(fmt.Sprintf(format, v...))
+}
+
+// typeName 字段的Go类型, Dict和Enum是按数据名和字段路径命名的类型
+func (t *ToGo) typeName(ft *schema.Type, path []string) string {
+	switch ft.Kind {
+	case schema.Int:
+		return "int"
+	case schema.Float:
+		return "float64"
+	case schema.Str:
+		return "string"
+	case schema.Bool:
+		return "bool"
+	case schema.List:
+		return "[]" + t.typeName(ft.Elem, path)
+	case schema.Dict:
+		return exported(t.DataName) + schema.TypeName(path...) + "Dict"
+	case schema.Enum:
+		return exported(t.DataName) + schema.TypeName(path...) + "Enum"
+	case schema.Func:
+		// 数值或者[类型, 参数]的数组
+		return "json.RawMessage"
+	}
+	return "interface{}"
+}
+
+// writeNested 先声明字段类型中用到的Enum和Dict类型
+func (t *ToGo) writeNested(ft *schema.Type, path []string) {
+	switch ft.Kind {
+	case schema.List:
+		t.writeNested(ft.Elem, path)
+	case schema.Enum:
+		name := t.typeName(ft, path)
+		prefix := strings.TrimSuffix(name, "Enum")
+		t.printf("type %s int\n\n", name)
+		t.printf("const (\n")
+		for _, value := range ft.Enum {
+			t.printf("%s%s %s = %d\n", prefix, exported(value.Name), name, value.Value)
+		}
+		t.printf(")\n\n")
+	case schema.Dict:
+		for _, field := range ft.Fields {
+			t.writeNested(field.Type, append(path, field.Name))
+		}
+		t.printf("type %s struct {\n", t.typeName(ft, path))
+		t.writeFields(ft.Fields, path)
+		t.printf("}\n\n")
+	}
+}
+
+func (t *ToGo) writeFields(fields []*schema.Field, path []string) {
+	for i, name := range fieldNames(fields) {
+		field := fields[i]
+		t.printf("%s %s `json:%q`", name, t.typeName(field.Type, append(path, field.Name)), field.Name)
+		if ref := field.Type.Ref(); ref != "" {
+			t.printf(" // Ref(%s)", ref)
+		}
+		t.printf("\n")
+	}
+}
+
+// WriteSchema 生成<DataName>.go, 每个数据一个结构体和Load<DataName>(dir)函数, dir是to_json的out_dir
+// 第一列是Int时返回map[int]*DataName, 否则是map[string]*DataName, isMap数据返回*DataName
+func (t *ToGo) WriteSchema(table *schema.Table) {
+	name := exported(table.Name)
+	t.printf("// Code generated by exporterX from %s. DO NOT EDIT.\n\n", table.Name)
+	t.printf("package %s\n\n", t.Package)
+	t.printf("import (\n\"encoding/json\"\n")
+	if table.RowFile && !table.IsMap {
+		t.printf("\"fmt\"\n")
+	}
+	t.printf("\"os\"\n\"path/filepath\"\n)\n\n")
+	for _, field := range table.Fields {
+		t.writeNested(field.Type, []string{field.Name})
+	}
+	t.printf("type %s struct {\n", name)
+	t.writeFields(table.Fields, nil)
+	t.printf("}\n\n")
+
+	dir := []string{"dir"}
+	if table.SubPath != "" {
+		dir = append(dir, fmt.Sprintf("%q", table.SubPath))
+	}
+	keyType := "string"
+	if table.IntKey() {
+		keyType = "int"
+	}
+	switch {
+	case table.IsMap:
+		t.printf("// Load%s reads %s.json exported by to_json from dir.\n", name, table.Name)
+		t.printf("func Load%s(dir string) (*%s, error) {\n", name, name)
+		t.printf("content, err := os.ReadFile(filepath.Join(%s, %q))\n", strings.Join(dir, ", "), table.Name+".json")
+		t.printf("if err != nil {\nreturn nil, err\n}\n")
+		t.printf("data := &%s{}\n", name)
+		t.printf("if err := json.Unmarshal(content, data); err != nil {\nreturn nil, err\n}\n")
+		t.printf("return data, nil\n}\n")
+	case table.RowFile:
+		t.printf("// Load%s reads %s/index.json and every row file exported by to_json from dir.\n", name, table.Name)
+		t.printf("func Load%s(dir string) (map[%s]*%s, error) {\n", name, keyType, name)
+		t.printf("dir = filepath.Join(%s, %q)\n", strings.Join(dir, ", "), table.Name)
+		t.printf("content, err := os.ReadFile(filepath.Join(dir, \"index.json\"))\n")
+		t.printf("if err != nil {\nreturn nil, err\n}\n")
+		t.printf("var ids []%s\n", keyType)
+		t.printf("if err := json.Unmarshal(content, &ids); err != nil {\nreturn nil, err\n}\n")
+		t.printf("rows := make(map[%s]*%s, len(ids))\n", keyType, name)
+		t.printf("for _, id := range ids {\n")
+		t.printf("content, err := os.ReadFile(filepath.Join(dir, fmt.Sprint(id)+\".json\"))\n")
+		t.printf("if err != nil {\nreturn nil, err\n}\n")
+		t.printf("row := &%s{}\n", name)
+		t.printf("if err := json.Unmarshal(content, row); err != nil {\nreturn nil, err\n}\n")
+		t.printf("rows[id] = row\n}\n")
+		t.printf("return rows, nil\n}\n")
+	default:
+		t.printf("// Load%s reads %s.json exported by to_json from dir.\n", name, table.Name)
+		t.printf("func Load%s(dir string) (map[%s]*%s, error) {\n", name, keyType, name)
+		t.printf("content, err := os.ReadFile(filepath.Join(%s, %q))\n", strings.Join(dir, ", "), table.Name+".json")
+		t.printf("if err != nil {\nreturn nil, err\n}\n")
+		t.printf("rows := make(map[%s]*%s)\n", keyType, name)
+		t.printf("if err := json.Unmarshal(content, &rows); err != nil {\nreturn nil, err\n}\n")
+		t.printf("return rows, nil\n}\n")
+	}
+
+	content, err := format.Source(t.buffer.Bytes())
+	if err != nil {
+		t.logger.Panicf("format %s.go got error: %s", t.DataName, err.Error())
+	}
+	filePath := path.Join(t.OutPath, t.DataName+".go")
+	if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+		t.logger.Panicf(err.Error())
+	}
+}
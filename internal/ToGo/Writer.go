@@ -0,0 +1,24 @@
+package togo
+
+import (
+	exporter "exporterX/DataExporter"
+	factory "exporterX/DataExporter/Factory"
+	schema "exporterX/internal/Schema"
+)
+
+func init() {
+	factory.RegisterWriter(exporter.Tool_To_Go, func(target exporter.WriteTarget) exporter.Writer {
+		return NewToGo(target.DataDef.Name, target.OutDir, target.Output.Package)
+	})
+}
+
+// Begin 只根据数据结构生成代码, 不写数据
+func (t *ToGo) Begin(table *schema.Table) {
+	t.WriteSchema(table)
+}
+
+func (t *ToGo) WriteMapData(data map[string]interface{}) {}
+
+func (t *ToGo) WriteRows(data map[string]interface{}, keysOrder []string, rowsOrder []string) {}
+
+func (t *ToGo) Finish() {}
@@ -1,47 +1,76 @@
 package tojson
 
 import (
+	"bytes"
 	"encoding/json"
-	"errors"
-	"io/ioutil"
-	"log"
-	"os"
-	"path"
+	layout "exporterX/internal/Layout"
 )
 
+// ToJson 导出json, 文件结构见layout.Writer
 type ToJson struct {
-	logger        *log.Logger
-	DataName      string
-	OutPath       string
-	OneRowOneFile bool
-}
-
-func NewToJson(dataName string, outPath string, oneRowOneFile bool) *ToJson {
-	logger := log.New(os.Stdout, "["+dataName+"]: ", log.Lshortfile)
-	if _, err := os.Stat(path.Join(outPath, dataName+".json")); err == nil {
-		err := os.Remove(path.Join(outPath, dataName+".json"))
-		if err != nil {
-			logger.Panicf("%s is oneRowOneFile, delete %s got error %s", dataName, path.Join(outPath, dataName+".json"), err.Error())
-		}
+	*layout.Writer
+}
+
+func NewToJson(dataName string, outPath string, oneRowOneFile bool, compact bool) *ToJson {
+	return &ToJson{layout.NewWriter(dataName, outPath, oneRowOneFile, jsonEncoder{compact: compact})}
+}
+
+// jsonEncoder compact为false时用tab缩进
+type jsonEncoder struct {
+	compact bool
+}
+
+func (e jsonEncoder) Name() string { return "json" }
+
+func (e jsonEncoder) Ext() string { return ".json" }
+
+func (e jsonEncoder) BeginMap(buffer *bytes.Buffer, n int) {
+	buffer.WriteString("{")
+}
+
+func (e jsonEncoder) Key(buffer *bytes.Buffer, i int, key string) {
+	if i > 0 {
+		buffer.WriteString(",")
 	}
-	if oneRowOneFile {
-		outPath = path.Join(outPath, dataName)
-		err := os.RemoveAll(outPath)
-		if err != nil {
-			logger.Panicf("Prepare directory %s got error: %s", outPath, err.Error())
-		}
-		if _, err := os.Stat(outPath); errors.Is(err, os.ErrNotExist) {
-			err := os.Mkdir(outPath, os.ModePerm)
-			if err != nil {
-				logger.Panicf("Mkdir %s got error: %s", outPath, err.Error())
-			}
-		}
+	content, _ := json.Marshal(key)
+	buffer.Write(content)
+	buffer.WriteString(":")
+}
+
+func (e jsonEncoder) EndMap(buffer *bytes.Buffer) {
+	buffer.WriteString("}")
+}
+
+func (e jsonEncoder) BeginList(buffer *bytes.Buffer, n int) {
+	buffer.WriteString("[")
+}
+
+func (e jsonEncoder) Elem(buffer *bytes.Buffer, i int) {
+	if i > 0 {
+		buffer.WriteString(",")
 	}
-	return &ToJson{logger, dataName, outPath, oneRowOneFile}
 }
 
-func (t *ToJson) WriteData(data map[string]interface{}) {
-	filePath := path.Join(t.OutPath, t.DataName+".json")
-	content, _ := json.MarshalIndent(data, "", "\t")
-	ioutil.WriteFile(filePath, content, 0644)
+func (e jsonEncoder) EndList(buffer *bytes.Buffer) {
+	buffer.WriteString("]")
+}
+
+func (e jsonEncoder) Scalar(buffer *bytes.Buffer, value interface{}) error {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	buffer.Write(content)
+	return nil
+}
+
+func (e jsonEncoder) Format(content []byte) ([]byte, error) {
+	if e.compact {
+		return content, nil
+	}
+	var buffer bytes.Buffer
+	if err := json.Indent(&buffer, content, "", "\t"); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
 }
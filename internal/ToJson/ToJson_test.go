@@ -0,0 +1,117 @@
+package tojson
+
+import (
+	"encoding/json"
+	schema "exporterX/internal/Schema"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// trickyStrings 需要转义才能写成json字符串的单元格内容
+var trickyStrings = []string{
+	``,
+	`say "hi"`,
+	`C:\path\to\file`,
+	`\`,
+	`end\`,
+	"line1\nline2",
+	"cr\r\nlf",
+	"tab\there",
+	`a]]b[[c`,
+	`</script>`,
+	"中文，对话😀",
+	"ctrl\x01\x7f",
+	"nul\x00byte",
+	"\u2028\u2029",
+}
+
+// loadJson 读取导出的json文件
+func loadJson(t *testing.T, filePath string, v interface{}) {
+	t.Helper()
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("read %s got error: %s", filePath, err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		t.Fatalf("load %s got error: %s", filePath, err)
+	}
+}
+
+func TestWriteDataRoundTrip(t *testing.T) {
+	for _, compact := range []bool{true, false} {
+		dir := t.TempDir()
+		data := make(map[string]interface{})
+		rowsOrder := make([]string, 0, len(trickyStrings))
+		want := make(map[string]map[string]interface{})
+		for i, text := range trickyStrings {
+			key := strconv.Itoa(i + 1)
+			data[key] = map[string]interface{}{
+				"Id":    i + 1,
+				"Text":  text,
+				"Parts": []interface{}{text, "x"},
+				"D":     map[string]interface{}{"a": text},
+			}
+			want[key] = map[string]interface{}{
+				"Id":    float64(i + 1),
+				"Text":  text,
+				"Parts": []interface{}{text, "x"},
+				"D":     map[string]interface{}{"a": text},
+			}
+			rowsOrder = append(rowsOrder, key)
+		}
+		NewToJson("TrickyData", dir, false, compact).WriteData(data, []string{"Id", "Text", "Parts", "D"}, rowsOrder, false)
+
+		got := make(map[string]map[string]interface{})
+		loadJson(t, path.Join(dir, "TrickyData.json"), &got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("compact=%v TrickyData.json loads back as %#v, want %#v", compact, got, want)
+		}
+	}
+}
+
+func TestWriteMapDataRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	data := make(map[string]interface{})
+	want := make(map[string]interface{})
+	for _, text := range trickyStrings {
+		data["key "+text] = text
+		want["key "+text] = text
+	}
+	NewToJson("TrickyMap", dir, false, false).WriteData(data, nil, nil, true)
+
+	got := make(map[string]interface{})
+	loadJson(t, path.Join(dir, "TrickyMap.json"), &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TrickyMap.json loads back as %#v, want %#v", got, want)
+	}
+}
+
+func TestRowFileIndexes(t *testing.T) {
+	cases := []struct {
+		key  *schema.Type
+		rows []string
+		want []interface{}
+	}{
+		{&schema.Type{Kind: schema.Str}, []string{"1001", "sword", "20"}, []interface{}{"1001", "20", "sword"}},
+		{&schema.Type{Kind: schema.Int}, []string{"1001", "-7", "20"}, []interface{}{float64(-7), float64(20), float64(1001)}},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		data := make(map[string]interface{})
+		for _, id := range c.rows {
+			data[id] = map[string]interface{}{"Name": id}
+		}
+		writer := NewToJson("RowData", dir, true, true)
+		writer.Begin(&schema.Table{Name: "RowData", Key: c.key})
+		writer.WriteRows(data, []string{"Name"}, c.rows)
+
+		var got []interface{}
+		loadJson(t, path.Join(dir, "RowData", "index.json"), &got)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s key index.json loads back as %v, want %v", c.key.Kind, got, c.want)
+		}
+	}
+}
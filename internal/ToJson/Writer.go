@@ -0,0 +1,12 @@
+package tojson
+
+import (
+	exporter "exporterX/DataExporter"
+	factory "exporterX/DataExporter/Factory"
+)
+
+func init() {
+	factory.RegisterWriter(exporter.Tool_To_Json, func(target exporter.WriteTarget) exporter.Writer {
+		return NewToJson(target.DataDef.Name, target.OutDir, target.DataDef.RowFile, target.Output.Compact)
+	})
+}
@@ -0,0 +1,114 @@
+package tolua
+
+import (
+	"bytes"
+	schema "exporterX/internal/Schema"
+	"fmt"
+	"io/ioutil"
+	"path"
+)
+
+// annotationType 字段的EmmyLua类型, Dict和Enum是按字段路径命名的class和alias
+func (t *ToLua) annotationType(ft *schema.Type, path []string) string {
+	switch ft.Kind {
+	case schema.Int:
+		return "integer"
+	case schema.Float:
+		return "number"
+	case schema.Str:
+		return "string"
+	case schema.Bool:
+		return "boolean"
+	case schema.List:
+		elem := t.annotationType(ft.Elem, path)
+		if ft.Elem.Kind == schema.Func {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case schema.Dict:
+		return t.DataName + "." + schema.TypeName(path...) + "Dict"
+	case schema.Enum:
+		return t.DataName + "." + schema.TypeName(path...) + "Enum"
+	case schema.Func:
+		// 数值或者{类型, 参数}
+		return "number|any[]"
+	}
+	return "any"
+}
+
+// annotationField 不是合法标识符的字段写成["key"]
+func (t *ToLua) annotationField(name string) string {
+	if IsLuaIdentifier(name) {
+		return name
+	}
+	return "[" + t.convertStr(name) + "]"
+}
+
+func (t *ToLua) writeAnnotationFields(buffer *bytes.Buffer, fields []*schema.Field, path []string) {
+	for _, field := range fields {
+		buffer.WriteString(fmt.Sprintf("---@field %s %s", t.annotationField(field.Name), t.annotationType(field.Type, append(path, field.Name))))
+		if ref := field.Type.Ref(); ref != "" {
+			buffer.WriteString(fmt.Sprintf(" Ref(%s)", ref))
+		}
+		buffer.WriteString("\n")
+	}
+}
+
+// writeAnnotationNested 先声明字段类型中用到的Enum alias和Dict class
+func (t *ToLua) writeAnnotationNested(buffer *bytes.Buffer, ft *schema.Type, path []string) {
+	switch ft.Kind {
+	case schema.List:
+		t.writeAnnotationNested(buffer, ft.Elem, path)
+	case schema.Enum:
+		buffer.WriteString(fmt.Sprintf("---@alias %s\n", t.annotationType(ft, path)))
+		for _, value := range ft.Enum {
+			buffer.WriteString(fmt.Sprintf("---| %d # %s\n", value.Value, value.Name))
+		}
+		buffer.WriteString("\n")
+	case schema.Dict:
+		for _, field := range ft.Fields {
+			t.writeAnnotationNested(buffer, field.Type, append(path, field.Name))
+		}
+		buffer.WriteString(fmt.Sprintf("---@class %s\n", t.annotationType(ft, path)))
+		t.writeAnnotationFields(buffer, ft.Fields, path)
+		buffer.WriteString("\n")
+	}
+}
+
+// WriteAnnotations 生成<DataName>.d.lua, 之后WriteData导出的lua文件会标注_M的类型
+// 行数据的class是DataName, 整个表是DataNameTable; isMap数据的class是DataName, 每个key一个字段
+func (t *ToLua) WriteAnnotations(table *schema.Table) {
+	var buffer bytes.Buffer
+	buffer.WriteString("---@meta\n")
+	buffer.WriteString(fmt.Sprintf("-- Code generated by exporterX from %s. DO NOT EDIT.\n\n", table.Name))
+	for _, field := range table.Fields {
+		t.writeAnnotationNested(&buffer, field.Type, []string{field.Name})
+	}
+	buffer.WriteString(fmt.Sprintf("---@class %s\n", t.DataName))
+	t.writeAnnotationFields(&buffer, table.Fields, nil)
+	if !table.IsMap {
+		keyType := "string"
+		if table.IntKey() {
+			keyType = "integer"
+		}
+		buffer.WriteString(fmt.Sprintf("\n---@alias %sTable table<%s, %s>\n", t.DataName, keyType, t.DataName))
+		t.indexType = keyType + "[]"
+		t.tableType = t.DataName + "Table"
+	} else {
+		t.tableType = t.DataName
+	}
+	t.rowType = t.DataName
+
+	filePath := path.Join(t.OutPath, t.DataName+".d.lua")
+	if err := ioutil.WriteFile(filePath, buffer.Bytes(), 0644); err != nil {
+		t.logger.Panicf(err.Error())
+	}
+}
+
+// typeAnnotation 有注解时在local _M前面标注类型, 让require的结果有类型
+func (t *ToLua) typeAnnotation(typeName string) string {
+	if typeName == "" {
+		return ""
+	}
+	return "---@type " + typeName + "\n"
+}
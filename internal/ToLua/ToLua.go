@@ -2,10 +2,12 @@ package tolua
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"regexp"
 	"sort"
 	"strconv"
 )
@@ -32,12 +34,31 @@ return _M`
 var luaFileSuffix2 = `
 return _M`
 
+// LuaKeywords lua的保留字, 不能直接作为t.name使用
+var LuaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "goto": true, "if": true, "in": true,
+	"local": true, "nil": true, "not": true, "or": true, "repeat": true, "return": true,
+	"then": true, "true": true, "until": true, "while": true,
+}
+
+var luaIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// IsLuaIdentifier name是否可以在lua中直接写成{name=1}和t.name
+func IsLuaIdentifier(name string) bool {
+	return luaIdentifier.MatchString(name) && !LuaKeywords[name]
+}
+
 type ToLua struct {
 	logger        *log.Logger
 	DataName      string
 	OutPath       string
 	OneRowOneFile bool
 	hasEmptyTable bool
+	// WriteAnnotations之后才有, 导出的lua文件中_M的类型
+	rowType   string
+	tableType string
+	indexType string
 }
 
 func NewToLua(dataName string, outPath string, oneRowOneFile bool) *ToLua {
@@ -68,7 +89,7 @@ func NewToLua(dataName string, outPath string, oneRowOneFile bool) *ToLua {
 			logger.Panicf("Mkdir %s got error: %s", outPath, err.Error())
 		}
 	}
-	return &ToLua{logger, dataName, outPath, oneRowOneFile, false}
+	return &ToLua{logger: logger, DataName: dataName, OutPath: outPath, OneRowOneFile: oneRowOneFile}
 }
 
 func (t *ToLua) writeLuaFile(filePath string, elem ...string) {
@@ -97,6 +118,7 @@ func (t *ToLua) optimizeDataForLuaUse(data map[string]interface{}, keysOrder []s
 	for _, id := range rowsOrder {
 		row := data[id].(map[string]interface{})
 		rowSlice := make([]interface{}, len(keysOrder))
+		// GlobalProcess或者行hook只给部分行增加的字段, 其他行写成nil
 		for _, key := range keysOrder {
 			rowSlice[keys[key]-1] = row[key]
 		}
@@ -114,7 +136,7 @@ func (t *ToLua) convertKeysToLuaUse(data map[string]int) string {
 	var buffer bytes.Buffer
 	buffer.WriteString("local key = {")
 	for index, key := range sortedArr {
-		buffer.WriteString(key)
+		buffer.WriteString(t.convertKey(key))
 		buffer.WriteString("=")
 		buffer.WriteString(strconv.Itoa(index + 1))
 		buffer.WriteString(", ")
@@ -148,9 +170,7 @@ func (t *ToLua) convertIndexesToLuaUse(indexes []string) string {
 	} else {
 		sort.Strings(indexes)
 		for _, index := range indexes {
-			buffer.WriteString("\"")
-			buffer.WriteString(index)
-			buffer.WriteString("\"")
+			buffer.WriteString(t.convertStr(index))
 			buffer.WriteString(",")
 		}
 	}
@@ -170,14 +190,14 @@ func (t *ToLua) WriteData(data map[string]interface{}, keysOrder []string, rowsO
 				filePath = path.Join(t.OutPath, t.DataName, id+".lua")
 				content.WriteString(t.convertData(row))
 				if t.hasEmptyTable {
-					t.writeLuaFile(filePath, luaFilePrefixET, luaFilePrefix1, content.String(), luaFileSuffix2)
+					t.writeLuaFile(filePath, luaFilePrefixET, t.typeAnnotation(t.rowType), luaFilePrefix1, content.String(), luaFileSuffix2)
 				} else {
-					t.writeLuaFile(filePath, luaFilePrefix1, content.String(), luaFileSuffix2)
+					t.writeLuaFile(filePath, t.typeAnnotation(t.rowType), luaFilePrefix1, content.String(), luaFileSuffix2)
 				}
 			}
 			filePath = path.Join(t.OutPath, t.DataName, "index.lua")
 			indexesStr := t.convertIndexesToLuaUse(indexes)
-			t.writeLuaFile(filePath, luaFilePrefix1, indexesStr, luaFileSuffix2)
+			t.writeLuaFile(filePath, t.typeAnnotation(t.indexType), luaFilePrefix1, indexesStr, luaFileSuffix2)
 		} else {
 			var content bytes.Buffer
 			keys, formatdata := t.optimizeDataForLuaUse(data, keysOrder, rowsOder)
@@ -192,9 +212,9 @@ func (t *ToLua) WriteData(data map[string]interface{}, keysOrder []string, rowsO
 			content.WriteString("}\n")
 			keysStr := t.convertKeysToLuaUse(keys)
 			if t.hasEmptyTable {
-				t.writeLuaFile(filePath, keysStr, luaFilePrefixET, luaFilePrefix1, content.String(), luaFileSuffix1)
+				t.writeLuaFile(filePath, keysStr, luaFilePrefixET, t.typeAnnotation(t.tableType), luaFilePrefix1, content.String(), luaFileSuffix1)
 			} else {
-				t.writeLuaFile(filePath, keysStr, luaFilePrefix1, content.String(), luaFileSuffix1)
+				t.writeLuaFile(filePath, keysStr, t.typeAnnotation(t.tableType), luaFilePrefix1, content.String(), luaFileSuffix1)
 			}
 		}
 	} else {
@@ -208,22 +228,32 @@ func (t *ToLua) WriteData(data map[string]interface{}, keysOrder []string, rowsO
 		var content bytes.Buffer
 		content.WriteString("{\n")
 		for _, key := range sortedKey {
-			content.WriteString(key)
+			content.WriteString(t.convertKey(key))
 			content.WriteString("\t=\t")
 			content.WriteString(t.convertData(data[key]))
 			content.WriteString(",\n")
 		}
 		content.WriteString("}\n")
 		if t.hasEmptyTable {
-			t.writeLuaFile(filePath, luaFilePrefixET, luaFilePrefix1, content.String(), luaFileSuffix2)
+			t.writeLuaFile(filePath, luaFilePrefixET, t.typeAnnotation(t.tableType), luaFilePrefix1, content.String(), luaFileSuffix2)
 		} else {
-			t.writeLuaFile(filePath, luaFilePrefix1, content.String(), luaFileSuffix2)
+			t.writeLuaFile(filePath, t.typeAnnotation(t.tableType), luaFilePrefix1, content.String(), luaFileSuffix2)
 		}
 	}
 }
 
+// convertKey table构造中的key, 不是合法标识符时写成["key"]
+func (t *ToLua) convertKey(key string) string {
+	if IsLuaIdentifier(key) {
+		return key
+	}
+	return "[" + t.convertStr(key) + "]"
+}
+
 func (t *ToLua) convertData(a interface{}) string {
 	switch a.(type) {
+	case nil:
+		return "nil"
 	case int:
 		return t.convertInt(a.(int))
 	case float64:
@@ -258,8 +288,34 @@ func (t *ToLua) convertBool(a bool) string {
 	}
 }
 
+// convertStr 导出成lua字符串, 引号、反斜杠和控制字符都要转义, 其他字符原样保留
 func (t *ToLua) convertStr(a string) string {
-	return "\"" + a + "\""
+	var buffer bytes.Buffer
+	buffer.WriteByte('"')
+	for i := 0; i < len(a); i++ {
+		c := a[i]
+		switch c {
+		case '"':
+			buffer.WriteString("\\\"")
+		case '\\':
+			buffer.WriteString("\\\\")
+		case '\n':
+			buffer.WriteString("\\n")
+		case '\r':
+			buffer.WriteString("\\r")
+		case '\t':
+			buffer.WriteString("\\t")
+		default:
+			if c < 0x20 || c == 0x7f {
+				// lua 5.1没有\x转义, 用3位十进制避免和后面的数字连在一起
+				buffer.WriteString(fmt.Sprintf("\\%03d", c))
+			} else {
+				buffer.WriteByte(c)
+			}
+		}
+	}
+	buffer.WriteByte('"')
+	return buffer.String()
 }
 
 func (t *ToLua) convertList(a []interface{}) string {
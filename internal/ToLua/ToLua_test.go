@@ -0,0 +1,203 @@
+package tolua
+
+import (
+	schema "exporterX/internal/Schema"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// trickyStrings 需要转义才能写成lua字符串的单元格内容
+var trickyStrings = []string{
+	``,
+	`say "hi"`,
+	`it's`,
+	`C:\path\to\file`,
+	`\`,
+	`end\`,
+	"line1\nline2",
+	"cr\r\nlf",
+	"tab\there",
+	`a]]b[[c`,
+	`[==[x]==]`,
+	`--[[ not a comment ]]`,
+	"中文，对话😀",
+	"ctrl\x01\x7f",
+	"\x0123",
+	"nul\x00byte",
+}
+
+// loadLua 执行导出的lua文件, 返回文件的返回值
+func loadLua(t *testing.T, L *lua.LState, filePath string) lua.LValue {
+	t.Helper()
+	if err := L.DoFile(filePath); err != nil {
+		t.Fatalf("load %s got error: %s", filePath, err)
+	}
+	value := L.Get(-1)
+	L.Pop(1)
+	return value
+}
+
+func TestConvertStrRoundTrip(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	tl := &ToLua{}
+	for _, text := range trickyStrings {
+		code := "return " + tl.convertStr(text)
+		if err := L.DoString(code); err != nil {
+			t.Errorf("load %s got error: %s", code, err)
+			continue
+		}
+		if got := L.Get(-1); got.Type() != lua.LTString || string(got.(lua.LString)) != text {
+			t.Errorf("convertStr(%q) loads back as %q", text, got.String())
+		}
+		L.Pop(1)
+	}
+}
+
+func TestWriteDataRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	data := make(map[string]interface{})
+	rowsOrder := make([]string, 0, len(trickyStrings))
+	for i, text := range trickyStrings {
+		key := strconv.Itoa(i + 1)
+		data[key] = map[string]interface{}{
+			"Id":    i + 1,
+			"Text":  text,
+			"Parts": []interface{}{text, "x"},
+			"D":     map[string]interface{}{"a": text},
+		}
+		rowsOrder = append(rowsOrder, key)
+	}
+	NewToLua("TrickyData", dir, false).WriteData(data, []string{"Id", "Text", "Parts", "D"}, rowsOrder, false)
+
+	L := lua.NewState()
+	defer L.Close()
+	table, ok := loadLua(t, L, path.Join(dir, "TrickyData.lua")).(*lua.LTable)
+	if !ok {
+		t.Fatalf("TrickyData.lua does not return a table")
+	}
+	for i, text := range trickyStrings {
+		row, ok := table.RawGetInt(i + 1).(*lua.LTable)
+		if !ok {
+			t.Errorf("row %d is missing", i+1)
+			continue
+		}
+		if got := L.GetField(row, "Text"); got.String() != text {
+			t.Errorf("row %d Text = %q, want %q", i+1, got.String(), text)
+		}
+		parts, ok := L.GetField(row, "Parts").(*lua.LTable)
+		if !ok || parts.RawGetInt(1).String() != text || parts.RawGetInt(2).String() != "x" {
+			t.Errorf("row %d Parts does not load back as {%q, \"x\"}", i+1, text)
+		}
+		dict, ok := L.GetField(row, "D").(*lua.LTable)
+		if !ok || L.GetField(dict, "a").String() != text {
+			t.Errorf("row %d D.a does not load back as %q", i+1, text)
+		}
+	}
+}
+
+func TestWriteMapDataRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	data := make(map[string]interface{})
+	for i, text := range trickyStrings {
+		// 不是合法标识符的key写成["key"]
+		data["key "+text] = text
+		data[string(rune('a'+i))] = []interface{}{text}
+	}
+	NewToLua("TrickyMap", dir, false).WriteData(data, nil, nil, true)
+
+	L := lua.NewState()
+	defer L.Close()
+	table, ok := loadLua(t, L, path.Join(dir, "TrickyMap.lua")).(*lua.LTable)
+	if !ok {
+		t.Fatalf("TrickyMap.lua does not return a table")
+	}
+	for i, text := range trickyStrings {
+		if got := table.RawGetString("key " + text); got.String() != text {
+			t.Errorf("[%q] = %q, want %q", "key "+text, got.String(), text)
+		}
+		list, ok := table.RawGetString(string(rune('a' + i))).(*lua.LTable)
+		if !ok || list.RawGetInt(1).String() != text {
+			t.Errorf("%c does not load back as {%q}", 'a'+i, text)
+		}
+	}
+}
+
+func TestNonIdentifierKeys(t *testing.T) {
+	// 没有配置name_rule时中文, 数字开头, 带空格的字段名也可以导出
+	names := []string{"Id", "名字", "1st", "a b", "ok_name"}
+	dir := t.TempDir()
+	data := map[string]interface{}{
+		"1": map[string]interface{}{"Id": 1, "名字": "x", "1st": 1, "a b": true, "ok_name": "y"},
+		"2": map[string]interface{}{"Id": 2, "名字": "z", "1st": 2, "a b": false, "ok_name": "w"},
+	}
+	fields := make([]*schema.Field, 0, len(names))
+	for _, name := range names {
+		fields = append(fields, &schema.Field{Name: name, Type: &schema.Type{Kind: schema.Any}})
+	}
+	tl := NewToLua("NameData", dir, false)
+	tl.WriteAnnotations(&schema.Table{Name: "NameData", Key: &schema.Type{Kind: schema.Int}, Fields: fields})
+	tl.WriteData(data, names, []string{"1", "2"}, false)
+
+	content, err := ioutil.ReadFile(path.Join(dir, "NameData.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`["名字"]`, `["1st"]`, `["a b"]`} {
+		if !strings.Contains(string(content), key) {
+			t.Errorf("NameData.lua does not write %s with brackets", key)
+		}
+	}
+	annotations, err := ioutil.ReadFile(path.Join(dir, "NameData.d.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`---@field ["名字"]`, `---@field ["1st"]`, `---@field ok_name`} {
+		if !strings.Contains(string(annotations), field) {
+			t.Errorf("NameData.d.lua has no %s", field)
+		}
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	table, ok := loadLua(t, L, path.Join(dir, "NameData.lua")).(*lua.LTable)
+	if !ok {
+		t.Fatalf("NameData.lua does not return a table")
+	}
+	row, ok := table.RawGetInt(2).(*lua.LTable)
+	if !ok {
+		t.Fatalf("row 2 is missing")
+	}
+	if got := L.GetField(row, "名字"); got.String() != "z" {
+		t.Errorf(`row 2 ["名字"] = %s, want z`, got.String())
+	}
+	if got := L.GetField(row, "1st"); got.String() != "2" {
+		t.Errorf(`row 2 ["1st"] = %s, want 2`, got.String())
+	}
+	if got := L.GetField(row, "a b"); got != lua.LFalse {
+		t.Errorf(`row 2 ["a b"] = %s, want false`, got.String())
+	}
+}
+
+func TestNonIdentifierMapKeys(t *testing.T) {
+	dir := t.TempDir()
+	NewToLua("NameMap", dir, false).WriteData(map[string]interface{}{"最大等级": 100, "2x": "double"}, nil, nil, true)
+
+	L := lua.NewState()
+	defer L.Close()
+	table, ok := loadLua(t, L, path.Join(dir, "NameMap.lua")).(*lua.LTable)
+	if !ok {
+		t.Fatalf("NameMap.lua does not return a table")
+	}
+	if got := table.RawGetString("最大等级"); got.String() != "100" {
+		t.Errorf(`["最大等级"] = %s, want 100`, got.String())
+	}
+	if got := table.RawGetString("2x"); got.String() != "double" {
+		t.Errorf(`["2x"] = %s, want double`, got.String())
+	}
+}
@@ -0,0 +1,35 @@
+package tolua
+
+import (
+	exporter "exporterX/DataExporter"
+	factory "exporterX/DataExporter/Factory"
+	schema "exporterX/internal/Schema"
+)
+
+func init() {
+	factory.RegisterWriter(exporter.Tool_To_Lua, func(target exporter.WriteTarget) exporter.Writer {
+		return &luaWriter{NewToLua(target.DataDef.Name, target.OutDir, target.DataDef.RowFile), target.Output.Annotations}
+	})
+}
+
+// luaWriter annotations为true时在写数据之前生成EmmyLua注解, 导出的lua文件使用注解中的类型
+type luaWriter struct {
+	*ToLua
+	annotations bool
+}
+
+func (w *luaWriter) Begin(table *schema.Table) {
+	if w.annotations {
+		w.WriteAnnotations(table)
+	}
+}
+
+func (w *luaWriter) WriteMapData(data map[string]interface{}) {
+	w.WriteData(data, nil, nil, true)
+}
+
+func (w *luaWriter) WriteRows(data map[string]interface{}, keysOrder []string, rowsOrder []string) {
+	w.WriteData(data, keysOrder, rowsOrder, false)
+}
+
+func (w *luaWriter) Finish() {}
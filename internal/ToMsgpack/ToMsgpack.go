@@ -0,0 +1,137 @@
+package tomsgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	layout "exporterX/internal/Layout"
+	"math"
+)
+
+// ToMsgpack 导出MessagePack, 结构和ToJson完全一致, 行的key也是字符串
+type ToMsgpack struct {
+	*layout.Writer
+}
+
+func NewToMsgpack(dataName string, outPath string, oneRowOneFile bool) *ToMsgpack {
+	return &ToMsgpack{layout.NewWriter(dataName, outPath, oneRowOneFile, msgpackEncoder{})}
+}
+
+type msgpackEncoder struct{}
+
+func (e msgpackEncoder) Name() string { return "msgpack" }
+
+func (e msgpackEncoder) Ext() string { return ".msgpack" }
+
+func (e msgpackEncoder) BeginMap(buffer *bytes.Buffer, n int) {
+	writeHead(buffer, 0x80, 0xde, n)
+}
+
+func (e msgpackEncoder) Key(buffer *bytes.Buffer, i int, key string) {
+	writeString(buffer, key)
+}
+
+func (e msgpackEncoder) EndMap(buffer *bytes.Buffer) {}
+
+func (e msgpackEncoder) BeginList(buffer *bytes.Buffer, n int) {
+	writeHead(buffer, 0x90, 0xdc, n)
+}
+
+func (e msgpackEncoder) Elem(buffer *bytes.Buffer, i int) {}
+
+func (e msgpackEncoder) EndList(buffer *bytes.Buffer) {}
+
+func (e msgpackEncoder) Scalar(buffer *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buffer.WriteByte(0xc0)
+	case bool:
+		if v {
+			buffer.WriteByte(0xc3)
+		} else {
+			buffer.WriteByte(0xc2)
+		}
+	case int:
+		writeInt(buffer, int64(v))
+	case int64:
+		writeInt(buffer, v)
+	case float64:
+		buffer.WriteByte(0xcb)
+		binary.Write(buffer, binary.BigEndian, math.Float64bits(v))
+	case string:
+		writeString(buffer, v)
+	default:
+		return errors.New("unsupported type")
+	}
+	return nil
+}
+
+func (e msgpackEncoder) Format(content []byte) ([]byte, error) {
+	return content, nil
+}
+
+// writeHead 数组和map的头, 少于16个元素时是fix类型, 否则是16位或32位的长度
+func writeHead(buffer *bytes.Buffer, fix byte, code16 byte, n int) {
+	switch {
+	case n < 16:
+		buffer.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buffer.WriteByte(code16)
+		binary.Write(buffer, binary.BigEndian, uint16(n))
+	default:
+		buffer.WriteByte(code16 + 1)
+		binary.Write(buffer, binary.BigEndian, uint32(n))
+	}
+}
+
+// writeInt 使用能表示v的最短的格式
+func writeInt(buffer *bytes.Buffer, v int64) {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		buffer.WriteByte(byte(v))
+	case v < 0 && v >= -32:
+		buffer.WriteByte(byte(v))
+	case v > 0 && v <= math.MaxUint8:
+		buffer.WriteByte(0xcc)
+		buffer.WriteByte(byte(v))
+	case v > 0 && v <= math.MaxUint16:
+		buffer.WriteByte(0xcd)
+		binary.Write(buffer, binary.BigEndian, uint16(v))
+	case v > 0 && v <= math.MaxUint32:
+		buffer.WriteByte(0xce)
+		binary.Write(buffer, binary.BigEndian, uint32(v))
+	case v > 0:
+		buffer.WriteByte(0xcf)
+		binary.Write(buffer, binary.BigEndian, uint64(v))
+	case v >= math.MinInt8:
+		buffer.WriteByte(0xd0)
+		buffer.WriteByte(byte(v))
+	case v >= math.MinInt16:
+		buffer.WriteByte(0xd1)
+		binary.Write(buffer, binary.BigEndian, int16(v))
+	case v >= math.MinInt32:
+		buffer.WriteByte(0xd2)
+		binary.Write(buffer, binary.BigEndian, int32(v))
+	default:
+		buffer.WriteByte(0xd3)
+		binary.Write(buffer, binary.BigEndian, v)
+	}
+}
+
+func writeString(buffer *bytes.Buffer, s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		buffer.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buffer.WriteByte(0xd9)
+		buffer.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buffer.WriteByte(0xda)
+		binary.Write(buffer, binary.BigEndian, uint16(n))
+	default:
+		buffer.WriteByte(0xdb)
+		binary.Write(buffer, binary.BigEndian, uint32(n))
+	}
+	buffer.WriteString(s)
+}
@@ -0,0 +1,226 @@
+package tomsgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	schema "exporterX/internal/Schema"
+	"io/ioutil"
+	"math"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// decoder 只支持ToMsgpack会写出的格式, 整数都读成int
+type decoder struct {
+	t       *testing.T
+	content []byte
+}
+
+func (d *decoder) next(n int) []byte {
+	d.t.Helper()
+	if len(d.content) < n {
+		d.t.Fatalf("need %d bytes, only %d left", n, len(d.content))
+	}
+	b := d.content[:n]
+	d.content = d.content[n:]
+	return b
+}
+
+func (d *decoder) length(code byte, fix byte, code16 byte) int {
+	switch {
+	case code&0xf0 == fix:
+		return int(code & 0x0f)
+	case code == code16:
+		return int(binary.BigEndian.Uint16(d.next(2)))
+	default:
+		return int(binary.BigEndian.Uint32(d.next(4)))
+	}
+}
+
+func (d *decoder) value() interface{} {
+	d.t.Helper()
+	code := d.next(1)[0]
+	switch {
+	case code <= 0x7f:
+		return int(code)
+	case code >= 0xe0:
+		return int(int8(code))
+	case code&0xf0 == 0x80, code == 0xde, code == 0xdf:
+		n := d.length(code, 0x80, 0xde)
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, ok := d.value().(string)
+			if !ok {
+				d.t.Fatalf("map key is not a string")
+			}
+			m[key] = d.value()
+		}
+		return m
+	case code&0xf0 == 0x90, code == 0xdc, code == 0xdd:
+		n := d.length(code, 0x90, 0xdc)
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = d.value()
+		}
+		return list
+	case code&0xe0 == 0xa0:
+		return string(d.next(int(code & 0x1f)))
+	}
+	switch code {
+	case 0xc0:
+		return nil
+	case 0xc2:
+		return false
+	case 0xc3:
+		return true
+	case 0xcb:
+		return math.Float64frombits(binary.BigEndian.Uint64(d.next(8)))
+	case 0xcc:
+		return int(d.next(1)[0])
+	case 0xcd:
+		return int(binary.BigEndian.Uint16(d.next(2)))
+	case 0xce:
+		return int(binary.BigEndian.Uint32(d.next(4)))
+	case 0xcf:
+		return int(binary.BigEndian.Uint64(d.next(8)))
+	case 0xd0:
+		return int(int8(d.next(1)[0]))
+	case 0xd1:
+		return int(int16(binary.BigEndian.Uint16(d.next(2))))
+	case 0xd2:
+		return int(int32(binary.BigEndian.Uint32(d.next(4))))
+	case 0xd3:
+		return int(int64(binary.BigEndian.Uint64(d.next(8))))
+	case 0xd9:
+		return string(d.next(int(d.next(1)[0])))
+	case 0xda:
+		return string(d.next(int(binary.BigEndian.Uint16(d.next(2)))))
+	case 0xdb:
+		return string(d.next(int(binary.BigEndian.Uint32(d.next(4)))))
+	}
+	d.t.Fatalf("unknown code 0x%x", code)
+	return nil
+}
+
+// loadMsgpack 读取导出的文件, 文件必须正好是一个值
+func loadMsgpack(t *testing.T, filePath string) interface{} {
+	t.Helper()
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("read %s got error: %s", filePath, err)
+	}
+	d := &decoder{t: t, content: content}
+	v := d.value()
+	if len(d.content) > 0 {
+		t.Fatalf("%s has %d bytes after the value", filePath, len(d.content))
+	}
+	return v
+}
+
+func makeList(n int) []interface{} {
+	list := make([]interface{}, n)
+	for i := range list {
+		list[i] = i - n/2
+	}
+	return list
+}
+
+func makeMap(n int) map[string]interface{} {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		m["k"+strconv.Itoa(i)] = i
+	}
+	return m
+}
+
+func TestWriteHead(t *testing.T) {
+	cases := []struct {
+		n    int
+		want []byte
+	}{
+		{15, []byte{0x9f}},
+		{16, []byte{0xdc, 0x00, 0x10}},
+		{65535, []byte{0xdc, 0xff, 0xff}},
+		{65536, []byte{0xdd, 0x00, 0x01, 0x00, 0x00}},
+	}
+	for _, c := range cases {
+		var buffer bytes.Buffer
+		writeHead(&buffer, 0x90, 0xdc, c.n)
+		if !bytes.Equal(buffer.Bytes(), c.want) {
+			t.Errorf("array head of %d is % x, want % x", c.n, buffer.Bytes(), c.want)
+		}
+	}
+}
+
+func TestWriteMapDataRoundTrip(t *testing.T) {
+	data := map[string]interface{}{
+		"Ints": []interface{}{0, 1, 127, 128, 255, 256, 65535, 65536, math.MaxUint32, math.MaxUint32 + 1, math.MaxInt64,
+			-1, -32, -33, -128, -129, -32768, -32769, math.MinInt32, math.MinInt32 - 1, math.MinInt64},
+		"Floats":  []interface{}{0.0, 0.5, -1.25, 1e300, -math.MaxFloat64, math.SmallestNonzeroFloat64},
+		"Strs":    []interface{}{"", "中文", string(make([]byte, 31)), string(make([]byte, 32)), string(make([]byte, 256)), string(make([]byte, 65536))},
+		"Others":  []interface{}{nil, true, false},
+		"List15":  makeList(15),
+		"List16":  makeList(16),
+		"List64K": makeList(65536),
+		"Map15":   makeMap(15),
+		"Map16":   makeMap(16),
+		"Map64K":  makeMap(65536),
+	}
+	dir := t.TempDir()
+	NewToMsgpack("BigMap", dir, false).WriteData(data, nil, nil, true)
+
+	got := loadMsgpack(t, path.Join(dir, "BigMap.msgpack"))
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("BigMap.msgpack does not load back as the written data")
+	}
+}
+
+func TestWriteRowsRoundTrip(t *testing.T) {
+	data := map[string]interface{}{
+		"1": map[string]interface{}{"Id": 1, "Name": "a", "Extra": -7},
+		"2": map[string]interface{}{"Id": 2, "Name": "b"},
+	}
+	dir := t.TempDir()
+	NewToMsgpack("RowData", dir, false).WriteData(data, []string{"Id", "Name"}, []string{"2", "1"}, false)
+
+	want := map[string]interface{}{
+		"1": map[string]interface{}{"Id": 1, "Name": "a"},
+		"2": map[string]interface{}{"Id": 2, "Name": "b"},
+	}
+	got := loadMsgpack(t, path.Join(dir, "RowData.msgpack"))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RowData.msgpack loads back as %v, want %v", got, want)
+	}
+}
+
+func TestRowFileIndexes(t *testing.T) {
+	cases := []struct {
+		key  *schema.Type
+		rows []string
+		want []interface{}
+	}{
+		{&schema.Type{Kind: schema.Str}, []string{"1001", "sword", "20"}, []interface{}{"1001", "20", "sword"}},
+		{&schema.Type{Kind: schema.Int}, []string{"1001", "-7", "20"}, []interface{}{-7, 20, 1001}},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		data := make(map[string]interface{})
+		for _, id := range c.rows {
+			data[id] = map[string]interface{}{"Name": id}
+		}
+		writer := NewToMsgpack("RowData", dir, true)
+		writer.Begin(&schema.Table{Name: "RowData", Key: c.key})
+		writer.WriteRows(data, []string{"Name"}, c.rows)
+
+		got := loadMsgpack(t, path.Join(dir, "RowData", "index.msgpack"))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s key index.msgpack loads back as %v, want %v", c.key.Kind, got, c.want)
+		}
+		row := loadMsgpack(t, path.Join(dir, "RowData", c.rows[1]+".msgpack"))
+		if !reflect.DeepEqual(row, data[c.rows[1]]) {
+			t.Errorf("%s.msgpack loads back as %v", c.rows[1], row)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package tomsgpack
+
+import (
+	exporter "exporterX/DataExporter"
+	factory "exporterX/DataExporter/Factory"
+)
+
+func init() {
+	factory.RegisterWriter(exporter.Tool_To_Msgpack, func(target exporter.WriteTarget) exporter.Writer {
+		return NewToMsgpack(target.DataDef.Name, target.OutDir, target.DataDef.RowFile)
+	})
+}
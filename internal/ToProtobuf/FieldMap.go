@@ -0,0 +1,104 @@
+package toprotobuf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// FieldMapSuffix 字段编号保存在out_dir中的<DataName>.fields.json
+const FieldMapSuffix = ".fields.json"
+
+// 19000到19999是protobuf保留的字段编号
+const (
+	firstReservedNumber = 19000
+	lastReservedNumber  = 19999
+)
+
+// FieldMap 每个message中字段名到字段编号的映射, 编号一旦分配就不再改变,
+// 删除的字段也保留编号, 新增的字段使用这个message中最大的编号+1, 保证旧的客户端仍然可以读取
+type FieldMap struct {
+	filePath string
+	messages map[string]map[string]int
+	changed  bool
+}
+
+// LoadFieldMap 读取filePath中的映射, 不存在时依次读取fallbacks中的, 都不存在时是空的映射
+// Save总是写到filePath, 从fallbacks读取时即使没有新的编号也会写
+func LoadFieldMap(filePath string, fallbacks ...string) (*FieldMap, error) {
+	m := &FieldMap{filePath: filePath, messages: make(map[string]map[string]int)}
+	for i, candidate := range append([]string{filePath}, fallbacks...) {
+		content, err := ioutil.ReadFile(candidate)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(content, &m.messages); err != nil {
+			return nil, fmt.Errorf("%s: %s", candidate, err.Error())
+		}
+		m.changed = i > 0
+		return m, nil
+	}
+	return m, nil
+}
+
+// Number 字段的编号, 没有编号的字段分配一个新的编号
+func (m *FieldMap) Number(message string, field string) int {
+	numbers, ok := m.messages[message]
+	if !ok {
+		numbers = make(map[string]int)
+		m.messages[message] = numbers
+	}
+	if number, ok := numbers[field]; ok {
+		return number
+	}
+	number := 1
+	for _, used := range numbers {
+		if used >= number {
+			number = used + 1
+		}
+	}
+	if number >= firstReservedNumber && number <= lastReservedNumber {
+		number = lastReservedNumber + 1
+	}
+	numbers[field] = number
+	m.changed = true
+	return number
+}
+
+// Removed message中已经不存在的字段, 按编号排序, 生成.proto时作为reserved
+func (m *FieldMap) Removed(message string, present map[string]bool) []string {
+	removed := make([]string, 0)
+	for field := range m.messages[message] {
+		if !present[field] {
+			removed = append(removed, field)
+		}
+	}
+	numbers := m.messages[message]
+	sort.Slice(removed, func(i, j int) bool { return numbers[removed[i]] < numbers[removed[j]] })
+	return removed
+}
+
+func (m *FieldMap) NumberOf(message string, field string) int {
+	return m.messages[message][field]
+}
+
+// Save 有新分配的编号时写回文件
+func (m *FieldMap) Save() error {
+	if !m.changed {
+		return nil
+	}
+	content, err := json.MarshalIndent(m.messages, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.filePath, content, 0644); err != nil {
+		return err
+	}
+	m.changed = false
+	return nil
+}
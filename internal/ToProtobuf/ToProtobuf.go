@@ -0,0 +1,588 @@
+package toprotobuf
+
+import (
+	"bytes"
+	"encoding/binary"
+	schema "exporterX/internal/Schema"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const DefaultPackage = "datatables"
+
+// valueMessage lua劫持和GlobalProcess.lua新增的字段类型未知, 使用google.protobuf.Value
+const valueMessage = "google.protobuf.Value"
+
+// funcMessage Func的值, oneof中的字段编号就是[类型, 参数]中的类型, 数值是1
+const funcMessage = "FuncValue"
+
+// protobuf的wire type
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+var protoInvalidChar = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ToProtobuf 根据数据结构生成<DataName>.proto, 按生成的message把数据写成<DataName>.pb
+// 字段编号来自FieldMap, 多次导出之间保持不变
+type ToProtobuf struct {
+	logger   *log.Logger
+	DataName string
+	OutPath  string
+	Package  string
+	fields   *FieldMap
+	buffer   bytes.Buffer
+	indent   int
+}
+
+func NewToProtobuf(dataName string, outPath string, pkg string, fields *FieldMap) *ToProtobuf {
+	logger := log.New(os.Stdout, "["+dataName+"]: ", log.Lshortfile)
+	if _, err := os.Stat(outPath); os.IsNotExist(err) {
+		os.MkdirAll(outPath, os.ModePerm)
+	}
+	if pkg == "" {
+		pkg = DefaultPackage
+	}
+	return &ToProtobuf{logger: logger, DataName: dataName, OutPath: outPath, Package: pkg, fields: fields}
+}
+
+// fieldName 不能作为protobuf名字的字符换成_
+func fieldName(name string) string {
+	name = protoInvalidChar.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// typeName 按字段路径命名的嵌套类型, 都声明在DataName的message中
+func typeName(path []string) string {
+	names := make([]string, 0, len(path))
+	for _, name := range path {
+		names = append(names, fieldName(name))
+	}
+	return schema.TypeName(names...)
+}
+
+// messageKey 一个message在FieldMap中的名字
+func (t *ToProtobuf) messageKey(message string) string {
+	if message == "" {
+		return t.DataName
+	}
+	return t.DataName + "." + message
+}
+
+func (t *ToProtobuf) line(format string, v ...interface{}) {
+	if format == "" {
+		t.buffer.WriteString("\n")
+		return
+	}
+	t.buffer.WriteString(strings.Repeat("    ", t.indent))
+	t.buffer.WriteString(fmt.Sprintf(format, v...))
+	t.buffer.WriteString("\n")
+}
+
+// valueType 一个值的类型, List(List(...))的内层List是<字段名>List的包装message, 再内层是<字段名>ListList
+func valueType(ft *schema.Type, path []string, depth int) string {
+	switch ft.Kind {
+	case schema.Int:
+		return "int64"
+	case schema.Float:
+		return "double"
+	case schema.Str:
+		return "string"
+	case schema.Bool:
+		return "bool"
+	case schema.List:
+		return typeName(path) + strings.Repeat("List", depth)
+	case schema.Dict:
+		return typeName(path) + "Dict"
+	case schema.Enum:
+		return typeName(path) + "Enum"
+	case schema.Func:
+		return funcMessage
+	}
+	return valueMessage
+}
+
+func fieldType(ft *schema.Type, path []string) string {
+	if ft.Kind == schema.List {
+		return "repeated " + valueType(ft.Elem, path, 1)
+	}
+	return valueType(ft, path, 0)
+}
+
+// uses 字段类型中是否用到了kind
+func uses(fields []*schema.Field, kind string) bool {
+	for _, field := range fields {
+		ft := field.Type
+		for ft.Kind == schema.List {
+			ft = ft.Elem
+		}
+		if ft.Kind == kind || (ft.Kind == schema.Dict && uses(ft.Fields, kind)) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNested 先声明字段类型中用到的包装message, Dict的message和Enum
+func (t *ToProtobuf) writeNested(ft *schema.Type, path []string, depth int) {
+	switch ft.Kind {
+	case schema.List:
+		if depth > 0 {
+			t.line("message %s {", valueType(ft, path, depth))
+			t.indent++
+			t.line("repeated %s values = 1;", valueType(ft.Elem, path, depth+1))
+			t.indent--
+			t.line("}")
+			t.line("")
+		}
+		t.writeNested(ft.Elem, path, depth+1)
+	case schema.Dict:
+		for _, field := range ft.Fields {
+			t.writeNested(field.Type, append(path, field.Name), 0)
+		}
+		name := valueType(ft, path, depth)
+		t.line("message %s {", name)
+		t.indent++
+		t.writeFields(name, ft.Fields, path)
+		t.indent--
+		t.line("}")
+		t.line("")
+	case schema.Enum:
+		name := valueType(ft, path, depth)
+		t.line("enum %s {", name)
+		t.indent++
+		// proto3的enum第一个值必须是0
+		values := make([]schema.EnumValue, 0, len(ft.Enum)+1)
+		seen := make(map[int]bool, len(ft.Enum))
+		alias := false
+		for _, value := range ft.Enum {
+			if value.Value == 0 {
+				values = append(values, value)
+			}
+			alias = alias || seen[value.Value]
+			seen[value.Value] = true
+		}
+		if len(values) == 0 {
+			values = append(values, schema.EnumValue{Name: "UNSPECIFIED"})
+		}
+		for _, value := range ft.Enum {
+			if value.Value != 0 {
+				values = append(values, value)
+			}
+		}
+		if alias {
+			t.line("option allow_alias = true;")
+		}
+		for _, value := range values {
+			t.line("%s_%s = %d;", name, fieldName(value.Name), value.Value)
+		}
+		t.indent--
+		t.line("}")
+		t.line("")
+	}
+}
+
+// writeFields 写message的字段, 不再存在的字段的编号和名字是reserved
+func (t *ToProtobuf) writeFields(message string, fields []*schema.Field, path []string) {
+	key := t.messageKey(message)
+	present := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		present[field.Name] = true
+		name := fieldName(field.Name)
+		option := ""
+		if name != field.Name {
+			option = fmt.Sprintf(" [json_name = %q]", field.Name)
+		}
+		comment := ""
+		if ref := field.Type.Ref(); ref != "" {
+			comment = fmt.Sprintf(" // Ref(%s)", ref)
+		}
+		t.line("%s %s = %d%s;%s", fieldType(field.Type, append(path, field.Name)), name, t.fields.Number(key, field.Name), option, comment)
+	}
+	if removed := t.fields.Removed(key, present); len(removed) > 0 {
+		numbers := make([]string, 0, len(removed))
+		names := make([]string, 0, len(removed))
+		for _, field := range removed {
+			numbers = append(numbers, fmt.Sprint(t.fields.NumberOf(key, field)))
+			names = append(names, fmt.Sprintf("%q", fieldName(field)))
+		}
+		t.line("reserved %s;", strings.Join(numbers, ", "))
+		t.line("reserved %s;", strings.Join(names, ", "))
+	}
+}
+
+func (t *ToProtobuf) writeFuncMessage() {
+	t.line("// A number, or [kind, payload]; the field number in the oneof is the kind")
+	t.line("message %s {", funcMessage)
+	t.indent++
+	t.line("// Switch: use coefficients when the param is in [from, to]")
+	t.line("message Range {")
+	t.line("    double from = 1;")
+	t.line("    double to = 2;")
+	t.line("    repeated double coefficients = 3;")
+	t.line("}")
+	t.line("")
+	t.line("message Switch {")
+	t.line("    repeated Range ranges = 1;")
+	t.line("}")
+	t.line("")
+	t.line("message Awaken {")
+	t.line("    repeated double values = 1;")
+	t.line("}")
+	t.line("")
+	t.line("// Func1: a, b, c of a*x*x+b*x+c")
+	t.line("message Func1 {")
+	t.line("    repeated double coefficients = 1;")
+	t.line("}")
+	t.line("")
+	t.line("oneof value {")
+	t.line("    double number = 1;")
+	t.line("    Switch switch_value = 2;")
+	t.line("    Awaken awaken = 3;")
+	t.line("    Func1 func1 = 4;")
+	t.line("}")
+	t.indent--
+	t.line("}")
+	t.line("")
+}
+
+// writeProto 生成<DataName>.proto, 同时给所有字段分配编号
+func (t *ToProtobuf) writeProto(table *schema.Table) {
+	t.line("// Code generated by exporterX from %s. DO NOT EDIT.", table.Name)
+	if table.IsMap {
+		t.line("// %s.pb is one length-delimited %s message.", t.DataName, t.DataName)
+	} else {
+		t.line("// %s.pb is length-delimited %s messages, one per row.", t.DataName, t.DataName)
+	}
+	t.line("syntax = \"proto3\";")
+	t.line("")
+	t.line("package %s;", t.Package)
+	t.line("")
+	if uses(table.Fields, schema.Any) {
+		t.line("import \"google/protobuf/struct.proto\";")
+		t.line("")
+	}
+	t.line("message %s {", t.DataName)
+	t.indent++
+	for _, field := range table.Fields {
+		t.writeNested(field.Type, []string{field.Name}, 0)
+	}
+	if uses(table.Fields, schema.Func) {
+		t.writeFuncMessage()
+	}
+	t.writeFields("", table.Fields, nil)
+	t.indent--
+	t.line("}")
+
+	filePath := path.Join(t.OutPath, t.DataName+".proto")
+	if err := ioutil.WriteFile(filePath, t.buffer.Bytes(), 0644); err != nil {
+		t.logger.Panicf(err.Error())
+	}
+}
+
+func writeUvarint(buffer *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buffer.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func writeTag(buffer *bytes.Buffer, number int, wire int) {
+	writeUvarint(buffer, uint64(number)<<3|uint64(wire))
+}
+
+func writeDouble(buffer *bytes.Buffer, v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	buffer.Write(b[:])
+}
+
+func writeBytes(buffer *bytes.Buffer, number int, content []byte) {
+	writeTag(buffer, number, wireBytes)
+	writeUvarint(buffer, uint64(len(content)))
+	buffer.Write(content)
+}
+
+func (t *ToProtobuf) toInt(value interface{}, name string) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		if v == math.Trunc(v) {
+			return int64(v)
+		}
+	}
+	t.logger.Panicf("%s is %T %v, cannot write as integer", name, value, value)
+	return 0
+}
+
+func (t *ToProtobuf) toFloat(value interface{}, name string) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	t.logger.Panicf("%s is %T %v, cannot write as number", name, value, value)
+	return 0
+}
+
+func (t *ToProtobuf) toList(value interface{}, name string) []interface{} {
+	if list, ok := value.([]interface{}); ok {
+		return list
+	}
+	t.logger.Panicf("%s is %T %v, cannot write as list", name, value, value)
+	return nil
+}
+
+// toDict lua处理后空的Dict会变成空的List
+func (t *ToProtobuf) toDict(value interface{}, name string) map[string]interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v
+	case []interface{}:
+		if len(v) == 0 {
+			return map[string]interface{}{}
+		}
+	}
+	t.logger.Panicf("%s is %T %v, cannot write as dict", name, value, value)
+	return nil
+}
+
+// packed 可以packed编码的repeated字段
+func packed(ft *schema.Type) bool {
+	switch ft.Kind {
+	case schema.Int, schema.Float, schema.Bool, schema.Enum:
+		return true
+	}
+	return false
+}
+
+// writeScalar packed编码中的一个值
+func (t *ToProtobuf) writeScalar(buffer *bytes.Buffer, ft *schema.Type, value interface{}, name string) {
+	switch ft.Kind {
+	case schema.Float:
+		writeDouble(buffer, t.toFloat(value, name))
+	case schema.Bool:
+		if v, ok := value.(bool); ok && v {
+			writeUvarint(buffer, 1)
+		} else if ok {
+			writeUvarint(buffer, 0)
+		} else {
+			t.logger.Panicf("%s is %T %v, cannot write as bool", name, value, value)
+		}
+	default:
+		writeUvarint(buffer, uint64(t.toInt(value, name)))
+	}
+}
+
+// writeField 写一个字段, proto3中值为默认值的标量不写
+func (t *ToProtobuf) writeField(buffer *bytes.Buffer, ft *schema.Type, path []string, number int, value interface{}, name string) {
+	if ft.Kind == schema.List {
+		t.writeRepeated(buffer, ft.Elem, path, 1, number, t.toList(value, name), name)
+		return
+	}
+	t.writeValue(buffer, ft, path, 0, number, value, name, false)
+}
+
+func (t *ToProtobuf) writeRepeated(buffer *bytes.Buffer, elem *schema.Type, path []string, depth int, number int, list []interface{}, name string) {
+	if !packed(elem) {
+		for _, value := range list {
+			t.writeValue(buffer, elem, path, depth, number, value, name, true)
+		}
+		return
+	}
+	if len(list) == 0 {
+		return
+	}
+	var content bytes.Buffer
+	for _, value := range list {
+		t.writeScalar(&content, elem, value, name)
+	}
+	writeBytes(buffer, number, content.Bytes())
+}
+
+// writeValue 写一个值, always为true时是repeated中的元素, 默认值也要写
+func (t *ToProtobuf) writeValue(buffer *bytes.Buffer, ft *schema.Type, path []string, depth int, number int, value interface{}, name string, always bool) {
+	switch ft.Kind {
+	case schema.Int, schema.Enum:
+		if v := t.toInt(value, name); v != 0 || always {
+			writeTag(buffer, number, wireVarint)
+			writeUvarint(buffer, uint64(v))
+		}
+	case schema.Float:
+		if v := t.toFloat(value, name); v != 0 || always {
+			writeTag(buffer, number, wireFixed64)
+			writeDouble(buffer, v)
+		}
+	case schema.Bool:
+		v, ok := value.(bool)
+		if !ok {
+			t.logger.Panicf("%s is %T %v, cannot write as bool", name, value, value)
+		}
+		if v || always {
+			writeTag(buffer, number, wireVarint)
+			t.writeScalar(buffer, ft, value, name)
+		}
+	case schema.Str:
+		v, ok := value.(string)
+		if !ok {
+			t.logger.Panicf("%s is %T %v, cannot write as string", name, value, value)
+		}
+		if v != "" || always {
+			writeBytes(buffer, number, []byte(v))
+		}
+	case schema.List:
+		// List(List(...))的内层是只有values = 1的包装message
+		var content bytes.Buffer
+		t.writeRepeated(&content, ft.Elem, path, depth+1, 1, t.toList(value, name), name)
+		writeBytes(buffer, number, content.Bytes())
+	case schema.Dict:
+		writeBytes(buffer, number, t.encodeObject(valueType(ft, path, depth), ft.Fields, path, t.toDict(value, name), name+".", true))
+	case schema.Func:
+		writeBytes(buffer, number, t.encodeFunc(value, name))
+	default:
+		writeBytes(buffer, number, t.encodeAny(value, name))
+	}
+}
+
+// encodeObject 按字段编号写一个message, strict为true时不在字段中的key是错误
+func (t *ToProtobuf) encodeObject(message string, fields []*schema.Field, path []string, data map[string]interface{}, prefix string, strict bool) []byte {
+	var buffer bytes.Buffer
+	key := t.messageKey(message)
+	present := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		present[field.Name] = true
+		value, ok := data[field.Name]
+		if !ok {
+			continue
+		}
+		t.writeField(&buffer, field.Type, append(path, field.Name), t.fields.NumberOf(key, field.Name), value, prefix+field.Name)
+	}
+	if strict {
+		for name := range data {
+			if !present[name] {
+				t.logger.Panicf("%s%s is not defined in %s", prefix, name, message)
+			}
+		}
+	}
+	return buffer.Bytes()
+}
+
+// encodeFunc 数值或者[类型, 参数], 和Header.parseFunc的结果一致
+func (t *ToProtobuf) encodeFunc(value interface{}, name string) []byte {
+	var buffer bytes.Buffer
+	list, ok := value.([]interface{})
+	if !ok {
+		writeTag(&buffer, 1, wireFixed64)
+		writeDouble(&buffer, t.toFloat(value, name))
+		return buffer.Bytes()
+	}
+	if len(list) != 2 {
+		t.logger.Panicf("%s is %v, Func need [kind, payload]", name, value)
+	}
+	kind := int(t.toInt(list[0], name))
+	payload := t.toList(list[1], name)
+	doubles := &schema.Type{Kind: schema.Float}
+	var content bytes.Buffer
+	switch kind {
+	case 2:
+		for _, group := range payload {
+			group := t.toList(group, name)
+			if len(group) != 3 {
+				t.logger.Panicf("%s is %v, Switch need [from, to, coefficients]", name, value)
+			}
+			var r bytes.Buffer
+			t.writeValue(&r, doubles, nil, 0, 1, group[0], name, false)
+			t.writeValue(&r, doubles, nil, 0, 2, group[1], name, false)
+			t.writeRepeated(&r, doubles, nil, 0, 3, t.toList(group[2], name), name)
+			writeBytes(&content, 1, r.Bytes())
+		}
+	case 3, 4:
+		t.writeRepeated(&content, doubles, nil, 0, 1, payload, name)
+	default:
+		t.logger.Panicf("%s is %v, Func kind %d is unknown", name, value, kind)
+	}
+	writeBytes(&buffer, kind, content.Bytes())
+	return buffer.Bytes()
+}
+
+// encodeAny 按google.protobuf.Value编码
+func (t *ToProtobuf) encodeAny(value interface{}, name string) []byte {
+	var buffer bytes.Buffer
+	switch v := value.(type) {
+	case nil:
+		writeTag(&buffer, 1, wireVarint)
+		writeUvarint(&buffer, 0)
+	case int, int64, float64:
+		writeTag(&buffer, 2, wireFixed64)
+		writeDouble(&buffer, t.toFloat(v, name))
+	case string:
+		writeBytes(&buffer, 3, []byte(v))
+	case bool:
+		writeTag(&buffer, 4, wireVarint)
+		t.writeScalar(&buffer, &schema.Type{Kind: schema.Bool}, v, name)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var fields bytes.Buffer
+		for _, key := range keys {
+			var entry bytes.Buffer
+			writeBytes(&entry, 1, []byte(key))
+			writeBytes(&entry, 2, t.encodeAny(v[key], name+"."+key))
+			writeBytes(&fields, 1, entry.Bytes())
+		}
+		writeBytes(&buffer, 5, fields.Bytes())
+	case []interface{}:
+		var values bytes.Buffer
+		for _, elem := range v {
+			writeBytes(&values, 1, t.encodeAny(elem, name))
+		}
+		writeBytes(&buffer, 6, values.Bytes())
+	default:
+		t.logger.Panicf("%s is %T %v, cannot write as google.protobuf.Value", name, value, value)
+	}
+	return buffer.Bytes()
+}
+
+// WriteData 生成<DataName>.proto和<DataName>.pb, pb中每行是一个带长度前缀的message, isMap数据只有一个message
+// 字段编号和它们一起写到OutPath中, 导出成功后一起提交
+func (t *ToProtobuf) WriteData(table *schema.Table, data map[string]interface{}, rowsOrder []string) {
+	t.writeProto(table)
+	var buffer bytes.Buffer
+	writeMessage := func(content []byte) {
+		writeUvarint(&buffer, uint64(len(content)))
+		buffer.Write(content)
+	}
+	if table.IsMap {
+		writeMessage(t.encodeObject("", table.Fields, nil, data, "", false))
+	} else {
+		for _, id := range rowsOrder {
+			writeMessage(t.encodeObject("", table.Fields, nil, t.toDict(data[id], id), id+".", false))
+		}
+	}
+	filePath := path.Join(t.OutPath, t.DataName+".pb")
+	if err := ioutil.WriteFile(filePath, buffer.Bytes(), 0644); err != nil {
+		t.logger.Panicf(err.Error())
+	}
+	if err := t.fields.Save(); err != nil {
+		t.logger.Panicf("save %s got error: %s", t.fields.filePath, err.Error())
+	}
+}
@@ -0,0 +1,43 @@
+package toprotobuf
+
+import (
+	exporter "exporterX/DataExporter"
+	factory "exporterX/DataExporter/Factory"
+	schema "exporterX/internal/Schema"
+	"log"
+	"path"
+)
+
+func init() {
+	factory.RegisterWriter(exporter.Tool_To_Protobuf, func(target exporter.WriteTarget) exporter.Writer {
+		// 字段编号和.proto一起写到staging, 导出成功后一起替换到out_dir; 同一次导出中再次写入时读取staging中的,
+		// 否则读取out_dir中上次导出的, 都没有时读取旧版本保存在excel旁边的, 不会写到src_dir
+		name := target.DataDef.Name + FieldMapSuffix
+		fieldsPath := path.Join(target.OutDir, name)
+		fields, err := LoadFieldMap(fieldsPath, path.Join(target.CommitDir, name), path.Join(path.Dir(target.FilePath), name))
+		if err != nil {
+			log.Panicf("[%s]: load %s got error: %s", target.DataDef.Name, name, err.Error())
+		}
+		return &protobufWriter{ToProtobuf: NewToProtobuf(target.DataDef.Name, target.OutDir, target.Output.Package, fields)}
+	})
+}
+
+// protobufWriter .proto和.pb都按Begin中的table生成
+type protobufWriter struct {
+	*ToProtobuf
+	table *schema.Table
+}
+
+func (w *protobufWriter) Begin(table *schema.Table) {
+	w.table = table
+}
+
+func (w *protobufWriter) WriteMapData(data map[string]interface{}) {
+	w.WriteData(w.table, data, nil)
+}
+
+func (w *protobufWriter) WriteRows(data map[string]interface{}, keysOrder []string, rowsOrder []string) {
+	w.WriteData(w.table, data, rowsOrder)
+}
+
+func (w *protobufWriter) Finish() {}
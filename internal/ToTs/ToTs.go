@@ -0,0 +1,219 @@
+package tots
+
+import (
+	"bytes"
+	"encoding/json"
+	schema "exporterX/internal/Schema"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// IndexFile 每个to_ts导出目标根目录下的公共文件
+const IndexFile = "index.d.ts"
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// ToTs 根据数据结构生成TypeScript类型定义, 描述to_json导出的数据
+type ToTs struct {
+	logger   *log.Logger
+	DataName string
+	OutPath  string
+	SubPath  string
+	buffer   bytes.Buffer
+	indent   int
+	useFunc  bool
+}
+
+func NewToTs(dataName string, outPath string, subPath string) *ToTs {
+	logger := log.New(os.Stdout, "["+dataName+"]: ", log.Lshortfile)
+	if _, err := os.Stat(outPath); os.IsNotExist(err) {
+		os.MkdirAll(outPath, os.ModePerm)
+	}
+	return &ToTs{logger: logger, DataName: dataName, OutPath: outPath, SubPath: subPath}
+}
+
+// propertyName 不是合法标识符的字段名写成字符串
+func propertyName(name string) string {
+	if tsIdentifier.MatchString(name) {
+		return name
+	}
+	quoted, _ := json.Marshal(name)
+	return string(quoted)
+}
+
+func (t *ToTs) line(format string, v ...interface{}) {
+	if format == "" {
+		t.buffer.WriteString("\n")
+		return
+	}
+	t.buffer.WriteString(strings.Repeat("    ", t.indent))
+	t.buffer.WriteString(fmt.Sprintf(format, v...))
+	t.buffer.WriteString("\n")
+}
+
+// typeName 字段的TypeScript类型, Dict是内联的接口, Enum是按数据名和字段路径命名的const enum
+func (t *ToTs) typeName(ft *schema.Type, path []string) string {
+	switch ft.Kind {
+	case schema.Int, schema.Float:
+		return "number"
+	case schema.Str:
+		return "string"
+	case schema.Bool:
+		return "boolean"
+	case schema.List:
+		elem := t.typeName(ft.Elem, path)
+		if ft.Elem.Kind == schema.Dict {
+			return "Array<" + elem + ">"
+		}
+		return elem + "[]"
+	case schema.Dict:
+		fields := make([]string, 0, len(ft.Fields))
+		for _, field := range ft.Fields {
+			fields = append(fields, fmt.Sprintf("%s: %s", propertyName(field.Name), t.typeName(field.Type, append(path, field.Name))))
+		}
+		return "{ " + strings.Join(fields, "; ") + " }"
+	case schema.Enum:
+		return t.DataName + schema.TypeName(path...) + "Enum"
+	case schema.Func:
+		t.useFunc = true
+		return "Func"
+	}
+	return "any"
+}
+
+// writeEnums 先声明字段类型中用到的Enum, Dict中的Enum也在顶层声明
+func (t *ToTs) writeEnums(ft *schema.Type, path []string) {
+	switch ft.Kind {
+	case schema.List:
+		t.writeEnums(ft.Elem, path)
+	case schema.Dict:
+		for _, field := range ft.Fields {
+			t.writeEnums(field.Type, append(path, field.Name))
+		}
+	case schema.Enum:
+		t.line("export const enum %s {", t.typeName(ft, path))
+		t.indent++
+		for _, value := range ft.Enum {
+			t.line("%s = %d,", propertyName(value.Name), value.Value)
+		}
+		t.indent--
+		t.line("}")
+		t.line("")
+	}
+}
+
+// indexPath 从subPath中的文件引用out_dir根目录的index.d.ts的相对路径
+func indexPath(subPath string) string {
+	rel := "."
+	for _, dir := range strings.Split(path.Clean(subPath), "/") {
+		if dir != "" && dir != "." {
+			rel = path.Join(rel, "..")
+		}
+	}
+	if !strings.HasPrefix(rel, ".") {
+		rel = "./" + rel
+	}
+	return rel + "/index"
+}
+
+// WriteSchema 生成<DataName>.d.ts, 行数据的接口是DataName, 整个表是DataNameTable,
+// 第一列是Int时key是number, 否则是string; isMap数据的DataNameTable就是DataName
+func (t *ToTs) WriteSchema(table *schema.Table) {
+	var body bytes.Buffer
+	t.buffer, body = body, t.buffer
+	for _, field := range table.Fields {
+		t.writeEnums(field.Type, []string{field.Name})
+	}
+	t.line("export interface %s {", t.DataName)
+	t.indent++
+	for _, field := range table.Fields {
+		if ref := field.Type.Ref(); ref != "" {
+			t.line("/** Ref(%s) */", ref)
+		}
+		t.line("%s: %s;", propertyName(field.Name), t.typeName(field.Type, []string{field.Name}))
+	}
+	t.indent--
+	t.line("}")
+	t.line("")
+	if table.IsMap {
+		t.line("export type %sTable = %s;", t.DataName, t.DataName)
+	} else {
+		keyType := "string"
+		if table.IntKey() {
+			keyType = "number"
+		}
+		if table.RowFile {
+			t.line("/** %s/index.json, every row is in %s/{id}.json */", t.DataName, t.DataName)
+			t.line("export type %sIndex = %s[];", t.DataName, keyType)
+			t.line("")
+		}
+		t.line("export type %sTable = Record<%s, %s>;", t.DataName, keyType, t.DataName)
+	}
+	t.buffer, body = body, t.buffer
+
+	t.line("// Code generated by exporterX from %s. DO NOT EDIT.", table.Name)
+	t.line("")
+	if t.useFunc {
+		t.line("import type { Func } from %q;", indexPath(t.SubPath))
+		t.line("")
+	}
+	t.buffer.Write(body.Bytes())
+
+	filePath := path.Join(t.OutPath, t.DataName+".d.ts")
+	if err := ioutil.WriteFile(filePath, t.buffer.Bytes(), 0644); err != nil {
+		t.logger.Panicf(err.Error())
+	}
+}
+
+// IndexEntry index.d.ts中的一个数据
+type IndexEntry struct {
+	Name    string
+	SubPath string
+}
+
+// WriteIndex 在outPath下生成index.d.ts, 导出每个数据的类型, DataTables是DataName到整个表的类型,
+// Func和Header.parseFunc的结果一致, 第一个元素区分Switch(2), Awaken(3)和Func1(4)
+func WriteIndex(outPath string, entries []IndexEntry) error {
+	t := &ToTs{}
+	t.line("// Code generated by exporterX. DO NOT EDIT.")
+	t.line("")
+	t.line("/** A number, or [kind, payload] */")
+	t.line("export type Func =")
+	t.indent++
+	t.line("| number")
+	t.line("/** Switch: [from, to, [a, b, c]] groups, a*x*x+b*x+c */")
+	t.line("| [2, Array<[number, number, [number, number, number]]>]")
+	t.line("/** Awaken */")
+	t.line("| [3, number[]]")
+	t.line("/** Func1: [a, b, c], a*x*x+b*x+c */")
+	t.line("| [4, [number, number, number]];")
+	t.indent--
+	modules := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		module := path.Join(entry.SubPath, entry.Name)
+		if !strings.HasPrefix(module, ".") {
+			module = "./" + module
+		}
+		modules = append(modules, module)
+	}
+	if len(entries) > 0 {
+		t.line("")
+	}
+	for _, module := range modules {
+		t.line("export * from %q;", module)
+	}
+	t.line("")
+	t.line("export interface DataTables {")
+	t.indent++
+	for i, entry := range entries {
+		t.line("%s: import(%q).%sTable;", propertyName(entry.Name), modules[i], entry.Name)
+	}
+	t.indent--
+	t.line("}")
+	return ioutil.WriteFile(path.Join(outPath, IndexFile), t.buffer.Bytes(), 0644)
+}
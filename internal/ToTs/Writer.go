@@ -0,0 +1,34 @@
+package tots
+
+import (
+	exporter "exporterX/DataExporter"
+	factory "exporterX/DataExporter/Factory"
+	schema "exporterX/internal/Schema"
+)
+
+func init() {
+	factory.RegisterWriter(exporter.Tool_To_Ts, func(target exporter.WriteTarget) exporter.Writer {
+		return NewToTs(target.DataDef.Name, target.OutDir, target.DataDef.SubPath)
+	})
+	factory.RegisterIndexWriter(exporter.Tool_To_Ts, func(output exporter.OutputConf, dataDefs []exporter.DataDefine) ([]string, error) {
+		entries := make([]IndexEntry, 0, len(dataDefs))
+		for _, dataDef := range dataDefs {
+			entries = append(entries, IndexEntry{Name: dataDef.Name, SubPath: dataDef.SubPath})
+		}
+		if err := WriteIndex(output.OutDir, entries); err != nil {
+			return nil, err
+		}
+		return []string{IndexFile}, nil
+	})
+}
+
+// Begin 只根据数据结构生成代码, 不写数据
+func (t *ToTs) Begin(table *schema.Table) {
+	t.WriteSchema(table)
+}
+
+func (t *ToTs) WriteMapData(data map[string]interface{}) {}
+
+func (t *ToTs) WriteRows(data map[string]interface{}, keysOrder []string, rowsOrder []string) {}
+
+func (t *ToTs) Finish() {}